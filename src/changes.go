@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// workingChange は画面の外 (new_page.sh やテキストエディタ) で変更され、
+// まだ履歴に記録されていないファイル。
+type workingChange struct {
+	Path        string
+	Kind        string
+	DiffHTML    template.HTML
+	DiffIsEmpty bool
+	Binary      bool
+	Selected    bool
+}
+
+// manualChanges は manuals/ 以下の未記録の変更を、最新の版との差分付きで返す。
+func (a *app) manualChanges() ([]workingChange, error) {
+	dir, err := computeGitPath(a.projectRoot, a.manualRoot, ".")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := a.history.Changes(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	headID := ""
+	if head, err := a.history.Head(); err == nil {
+		headID = head.ID
+	} else if !errors.Is(err, errNoRevisions) {
+		return nil, err
+	}
+
+	changes := make([]workingChange, 0, len(paths))
+	for _, p := range paths {
+		var base []byte
+		recorded := false
+		if headID != "" {
+			data, err := a.history.ReadFile(headID, p)
+			switch {
+			case err == nil:
+				base, recorded = data, true
+			case !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, object.ErrFileNotFound):
+				return nil, err
+			}
+		}
+		current, err := os.ReadFile(a.repoAbsPath(p))
+		exists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		change := workingChange{Path: p}
+		switch {
+		case !recorded:
+			change.Kind = "追加"
+		case !exists:
+			change.Kind = "削除"
+		default:
+			change.Kind = "変更"
+		}
+		if utf8.Valid(base) && utf8.Valid(current) {
+			change.DiffHTML, change.DiffIsEmpty = renderDiff(base, current)
+		} else {
+			change.Binary = true
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (a *app) changesPageView(changes []workingChange, who editor, message string, flash *flashMessage) pageView {
+	return pageView{
+		Mode:        "changes",
+		SiteTitle:   siteTitle,
+		PageTitle:   "未記録の変更",
+		TOC:         a.currentTOC(),
+		Flash:       flash,
+		Changes:     changes,
+		Editors:     a.editors(),
+		EditEditor:  who.ID,
+		EditAuthor:  who.Name,
+		EditMessage: message,
+	}
+}
+
+// handleChanges は GET /changes で manuals/ 以下の未記録の変更を一覧し、
+// POST で選んだファイルだけを1つの版として記録する。
+func (a *app) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if a.history == nil {
+		view := a.changesPageView(nil, editor{}, "", &flashMessage{Type: "error", Message: "履歴の保存先がないため変更を記録できません。"})
+		a.render(w, view)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		changes, err := a.manualChanges()
+		if err != nil {
+			log.Printf("未記録の変更を確認できませんでした: %v", err)
+			http.Error(w, "作業コピーの状態を確認できませんでした", http.StatusInternalServerError)
+			return
+		}
+		for i := range changes {
+			changes[i].Selected = true
+		}
+		who, ok := a.rememberedEditor(r)
+		if !ok {
+			who = editor{Name: "マニュアル編集者"}
+		}
+		var flash *flashMessage
+		if r.URL.Query().Get("saved") == "1" {
+			flash = savedFlash(r.URL.Query())
+		}
+		a.render(w, a.changesPageView(changes, who, "", flash))
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "フォームの解析に失敗しました", http.StatusBadRequest)
+			return
+		}
+		message := strings.TrimSpace(r.PostFormValue("message"))
+		who := a.editorFromForm(r)
+		selected := r.PostForm["file"]
+
+		changes, err := a.manualChanges()
+		if err != nil {
+			log.Printf("未記録の変更を確認できませんでした: %v", err)
+			http.Error(w, "作業コピーの状態を確認できませんでした", http.StatusInternalServerError)
+			return
+		}
+		// 一覧にないパスは記録しない
+		var paths []string
+		for i, change := range changes {
+			if slices.Contains(selected, change.Path) {
+				changes[i].Selected = true
+				paths = append(paths, change.Path)
+			}
+		}
+		if len(paths) == 0 {
+			a.render(w, a.changesPageView(changes, who, message, &flashMessage{
+				Type:    "error",
+				Message: "記録するファイルを選んでください。",
+			}))
+			return
+		}
+
+		if who.Name == "" {
+			who = a.editorFor("マニュアル編集者")
+		} else {
+			rememberEditor(w, who)
+		}
+		if message == "" {
+			message = "作業コピーの変更を記録"
+		}
+
+		result, err := queueWrite(r.Context(), a.writes, func() (commitResult, error) {
+			return a.commitManual(who, message, false, paths...)
+		})
+		if err != nil {
+			note, invalid := validationNote(err)
+			switch {
+			case invalid:
+				// チェックの結果をそのまま表示する
+			case errors.Is(err, errNoChanges):
+				note = "選んだファイルに記録する変更がありませんでした。"
+			case errors.Is(err, errWriteTimeout):
+				note = "他の保存や同期の処理が混み合っているため、記録できませんでした。しばらくしてから再度お試しください。"
+			default:
+				log.Printf("未記録の変更を記録できませんでした: %v", err)
+				note = "履歴への記録に失敗しました。"
+			}
+			a.render(w, a.changesPageView(changes, who, message, &flashMessage{Type: "error", Message: note}))
+			return
+		}
+
+		// 目次を変更した場合は読み直して画面に反映する
+		indexPath, err := computeGitPath(a.projectRoot, a.manualRoot, "index.yaml")
+		if err == nil && slices.Contains(paths, indexPath) {
+			if err := a.reloadIndex(); err != nil {
+				log.Printf("index.yaml を読み直せませんでした: %v", err)
+			}
+		}
+
+		http.Redirect(w, r, savedRedirect("/changes", result), http.StatusSeeOther)
+
+	default:
+		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checklistDir はページのチェックリストの状態を記録するディレクトリ (プロジェクトルートからの相対パス)。
+// マニュアル本文とは別に、ページとシフトごとに1ファイルで記録する。
+const checklistDir = ".lfwiki/checklists"
+
+// taskItem は本文の `- [ ]` / `- [x]` の項目。Key は同じページの中で項目を区別する名前で、
+// 同じ文の項目が複数あれば2つ目以降に番号を付ける。
+type taskItem struct {
+	Key     string
+	Text    string
+	Checked bool
+}
+
+// renderTaskBox はタスクリストの項目の先頭にチェックボックスを書き出す。
+// 状態を記録できる画面では app.js が有効にする。
+func (r *mdRenderer) renderTaskBox(n *mdNode) {
+	if n.task == 0 {
+		return
+	}
+	inner := &mdRenderer{refs: r.refs, ctx: r.ctx}
+	inner.renderInline(n.content)
+	text := strings.Join(strings.Fields(html.UnescapeString(stripTags(inner.b.String()))), " ")
+
+	key := text
+	r.taskKeys[text]++
+	if count := r.taskKeys[text]; count > 1 {
+		key = fmt.Sprintf("%s #%d", text, count)
+	}
+	checked := n.task == taskChecked
+	r.tasks = append(r.tasks, taskItem{Key: key, Text: text, Checked: checked})
+
+	r.b.WriteString(`<input type="checkbox" class="task-list-item-checkbox" data-task="` + escapeHTML(key) + `"`)
+	if checked {
+		r.b.WriteString(` checked`)
+	}
+	r.b.WriteString(` disabled /> `)
+}
+
+// checklistMark は1つの項目の現在の状態と、最後に変えた人。
+type checklistMark struct {
+	Checked bool      `json:"checked"`
+	By      string    `json:"by"`
+	Email   string    `json:"email"`
+	At      time.Time `json:"at"`
+}
+
+// checklistEvent はチェック・チェックの取り消し・リセットの記録。
+type checklistEvent struct {
+	Action string    `json:"action"` // check, uncheck, reset
+	Task   string    `json:"task,omitempty"`
+	By     string    `json:"by"`
+	Email  string    `json:"email"`
+	At     time.Time `json:"at"`
+}
+
+type checklistState struct {
+	Items map[string]checklistMark `json:"items"`
+	Log   []checklistEvent         `json:"log"`
+}
+
+type checklistStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newChecklistStore(projectRoot string) *checklistStore {
+	return &checklistStore{dir: filepath.Join(projectRoot, filepath.FromSlash(checklistDir))}
+}
+
+func (s *checklistStore) pageDir(slug string) string {
+	return filepath.Join(s.dir, url.PathEscape(slug))
+}
+
+// load はページとシフトの状態を読み込む。記録がなければ空の状態を返す。
+func (s *checklistStore) load(slug string, sh shift) (checklistState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(slug, sh)
+}
+
+func (s *checklistStore) read(slug string, sh shift) (checklistState, error) {
+	state := checklistState{Items: make(map[string]checklistMark)}
+	data, err := os.ReadFile(filepath.Join(s.pageDir(slug), sh.Key()+".json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("チェックリストの記録が壊れています: %w", err)
+	}
+	if state.Items == nil {
+		state.Items = make(map[string]checklistMark)
+	}
+	return state, nil
+}
+
+// update は状態を読み込んで fn で書き換え、保存する。
+func (s *checklistStore) update(slug string, sh shift, fn func(*checklistState)) (checklistState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.read(slug, sh)
+	if err != nil {
+		return state, err
+	}
+	fn(&state)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return state, err
+	}
+	dir := s.pageDir(slug)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return state, err
+	}
+	p := filepath.Join(dir, sh.Key()+".json")
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return state, err
+	}
+	return state, os.Rename(tmp, p)
+}
+
+// shifts は記録があるシフトを新しい順に返す。
+func (s *checklistStore) shifts(slug string) ([]shift, error) {
+	entries, err := os.ReadDir(s.pageDir(slug))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var shifts []shift
+	for _, entry := range entries {
+		key, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if sh, ok := parseShiftKey(key); ok {
+			shifts = append(shifts, sh)
+		}
+	}
+	sort.Slice(shifts, func(i, j int) bool {
+		if !shifts[i].Date.Equal(shifts[j].Date) {
+			return shifts[i].Date.After(shifts[j].Date)
+		}
+		return shifts[i].Kind == shiftNight && shifts[j].Kind == shiftDay
+	})
+	return shifts, nil
+}
+
+// checklistView はページ上部のチェックリストの欄と、/checklist の記録の画面に表示する内容。
+type checklistView struct {
+	Page       string
+	PageTitle  string
+	PageLink   string
+	Shift      string
+	ShiftLabel string
+	Current    bool
+	Done       int
+	Total      int
+	States     map[string]checklistStateView
+	Shifts     []checklistShiftOption
+	Rows       []checklistRow
+	Log        []checklistLogRow
+}
+
+// checklistStateView は app.js に渡す項目の状態。
+type checklistStateView struct {
+	Checked bool   `json:"checked"`
+	By      string `json:"by"`
+	At      string `json:"at"`
+}
+
+type checklistShiftOption struct {
+	Key      string
+	Label    string
+	Selected bool
+}
+
+type checklistRow struct {
+	Text    string
+	Checked bool
+	By      string
+	At      string
+}
+
+type checklistLogRow struct {
+	At     string
+	By     string
+	Action string
+	Task   string
+}
+
+func checklistTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// buildChecklistView はページの項目と記録した状態を合わせる。記録のない項目は未チェックとする。
+func buildChecklistView(slug string, meta pageMeta, tasks []taskItem, sh, current shift, state checklistState) *checklistView {
+	view := &checklistView{
+		Page:       slug,
+		PageTitle:  meta.Title,
+		PageLink:   makePageLink(slug),
+		Shift:      sh.Key(),
+		ShiftLabel: sh.Label(),
+		Current:    sh.Key() == current.Key(),
+		Total:      len(tasks),
+		States:     make(map[string]checklistStateView),
+	}
+	for _, task := range tasks {
+		mark, ok := state.Items[task.Key]
+		row := checklistRow{Text: task.Text}
+		if ok {
+			row.Checked = mark.Checked
+			row.By = mark.By
+			row.At = checklistTime(mark.At)
+			view.States[task.Key] = checklistStateView{Checked: mark.Checked, By: mark.By, At: row.At}
+		}
+		if row.Checked {
+			view.Done++
+		}
+		view.Rows = append(view.Rows, row)
+	}
+	for i := len(state.Log) - 1; i >= 0; i-- {
+		event := state.Log[i]
+		row := checklistLogRow{At: checklistTime(event.At), By: event.By, Task: event.Task}
+		switch event.Action {
+		case "check":
+			row.Action = "チェック"
+		case "uncheck":
+			row.Action = "チェックを外す"
+		case "reset":
+			row.Action = "リセット"
+		default:
+			row.Action = event.Action
+		}
+		view.Log = append(view.Log, row)
+	}
+	return view
+}
+
+// pageChecklist はページの表示に添えるチェックリストの欄を返す。項目のないページでは nil。
+func (a *app) pageChecklist(slug string, meta pageMeta, tasks []taskItem) *checklistView {
+	if len(tasks) == 0 {
+		return nil
+	}
+	current := a.shifts.at(time.Now())
+	state, err := a.checklists.load(slug, current)
+	if err != nil {
+		log.Printf("ページ %s のチェックリストを読み込めませんでした: %v", slug, err)
+	}
+	return buildChecklistView(slug, meta, tasks, current, current, state)
+}
+
+// currentTasks は作業コピーのページの項目を返す。
+func (a *app) currentTasks(slug string, meta pageMeta) ([]taskItem, error) {
+	pages, toc := a.manualIndex()
+	page, err := a.loadManualPage(meta.RelFile, meta.GitPath, "", a.pageContext(slug, pages, toc, "", nil))
+	if err != nil {
+		return nil, err
+	}
+	return page.Tasks, nil
+}
+
+// handleChecklist は GET /checklist?page=&shift= でシフトごとのチェックの記録を表示し、
+// POST /checklist で項目のチェック (action=check) とシフトの状態のリセット (action=reset) を記録する。
+func (a *app) handleChecklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.showChecklist(w, r)
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "フォームの解析に失敗しました", http.StatusBadRequest)
+			return
+		}
+		switch r.PostFormValue("action") {
+		case "check":
+			a.checkTask(w, r)
+		case "reset":
+			a.resetChecklist(w, r)
+		default:
+			http.Error(w, "不明な操作です", http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+	}
+}
+
+// checklistEditor はチェックした人を返す。フォームで選んでいなければ Cookie に覚えた編集者を使う。
+func (a *app) checklistEditor(r *http.Request) editor {
+	who := a.editorFromForm(r)
+	if who.Name == "" {
+		if remembered, ok := a.rememberedEditor(r); ok {
+			who = a.editorFor(remembered.Name)
+		}
+	}
+	return who
+}
+
+func (a *app) checkTask(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimSpace(r.PostFormValue("page"))
+	meta, ok := a.lookupPage(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	current := a.shifts.at(time.Now())
+	if key := strings.TrimSpace(r.PostFormValue("shift")); key != "" && key != current.Key() {
+		http.Error(w, fmt.Sprintf("シフトが %s に変わりました。ページを開き直してください。", current.Label()), http.StatusConflict)
+		return
+	}
+
+	tasks, err := a.currentTasks(slug, meta)
+	if err != nil {
+		log.Printf("ページ %s の読み込みに失敗しました: %v", slug, err)
+		http.Error(w, "ページを読み込めませんでした", http.StatusInternalServerError)
+		return
+	}
+	key := r.PostFormValue("task")
+	found := false
+	for _, task := range tasks {
+		if task.Key == key {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "この項目はページにありません。ページを開き直してください。", http.StatusConflict)
+		return
+	}
+
+	who := a.checklistEditor(r)
+	if who.Name == "" {
+		http.Error(w, "チェックした人の名前を選ぶか入力してください。", http.StatusBadRequest)
+		return
+	}
+
+	checked := r.PostFormValue("checked") == "1"
+	now := time.Now()
+	action := "uncheck"
+	if checked {
+		action = "check"
+	}
+	_, err = a.checklists.update(slug, current, func(state *checklistState) {
+		state.Items[key] = checklistMark{Checked: checked, By: who.Name, Email: who.Email, At: now}
+		state.Log = append(state.Log, checklistEvent{Action: action, Task: key, By: who.Name, Email: who.Email, At: now})
+	})
+	if err != nil {
+		log.Printf("ページ %s のチェックを記録できませんでした: %v", slug, err)
+		http.Error(w, "チェックを記録できませんでした", http.StatusInternalServerError)
+		return
+	}
+	rememberEditor(w, who)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(checklistStateView{Checked: checked, By: who.Name, At: checklistTime(now)}); err != nil {
+		log.Printf("チェックの結果を返せませんでした: %v", err)
+	}
+}
+
+func (a *app) resetChecklist(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimSpace(r.PostFormValue("page"))
+	if _, ok := a.lookupPage(slug); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	current := a.shifts.at(time.Now())
+	who := a.checklistEditor(r)
+	if who.Name == "" {
+		who = a.editorFor("マニュアル編集者")
+	}
+	_, err := a.checklists.update(slug, current, func(state *checklistState) {
+		state.Items = make(map[string]checklistMark)
+		state.Log = append(state.Log, checklistEvent{Action: "reset", By: who.Name, Email: who.Email, At: time.Now()})
+	})
+	if err != nil {
+		log.Printf("ページ %s のチェックリストをリセットできませんでした: %v", slug, err)
+		http.Error(w, "チェックリストをリセットできませんでした", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, makePageLink(slug)+"?checklist=reset", http.StatusSeeOther)
+}
+
+func (a *app) showChecklist(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimSpace(r.URL.Query().Get("page"))
+	meta, ok := a.lookupPage(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	current := a.shifts.at(time.Now())
+	sh := current
+	if key := r.URL.Query().Get("shift"); key != "" {
+		if sh, ok = parseShiftKey(key); !ok {
+			http.Error(w, "シフトの指定が正しくありません", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tasks, err := a.currentTasks(slug, meta)
+	if err != nil {
+		log.Printf("ページ %s の読み込みに失敗しました: %v", slug, err)
+		http.Error(w, "ページを読み込めませんでした", http.StatusInternalServerError)
+		return
+	}
+	state, err := a.checklists.load(slug, sh)
+	if err != nil {
+		log.Printf("ページ %s のチェックリストを読み込めませんでした: %v", slug, err)
+		http.Error(w, "チェックリストを読み込めませんでした", http.StatusInternalServerError)
+		return
+	}
+	checklist := buildChecklistView(slug, meta, tasks, sh, current, state)
+
+	shifts, err := a.checklists.shifts(slug)
+	if err != nil {
+		log.Printf("ページ %s のチェックリストの一覧を取得できませんでした: %v", slug, err)
+	}
+	if len(shifts) == 0 || shifts[0].Key() != current.Key() {
+		shifts = append([]shift{current}, shifts...)
+	}
+	for _, s := range shifts {
+		checklist.Shifts = append(checklist.Shifts, checklistShiftOption{Key: s.Key(), Label: s.Label(), Selected: s.Key() == sh.Key()})
+	}
+
+	a.render(w, pageView{
+		Mode:      "checklist",
+		Slug:      slug,
+		SiteTitle: siteTitle,
+		PageTitle: "チェックの記録: " + meta.Title,
+		TOC:       a.currentTOC(),
+		Checklist: checklist,
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// shouldCoalesce は今回の保存を直前のコミット head にまとめてよいかを判断する。
+// head が編集画面からの保存で作ったコミットで、同じ編集者が head の作成日時 (まとめた版では最初の保存) から
+// a.coalesce 以内に同じページを保存し、そのコミットがまだ push されておらずリリースにも含まれていない場合だけまとめる。
+func (a *app) shouldCoalesce(head *object.Commit, email string, paths []string) bool {
+	if a.coalesce <= 0 || head == nil || head.NumParents() > 1 || head.Hash != a.lastEdit {
+		return false
+	}
+	if !strings.EqualFold(head.Author.Email, email) || time.Since(head.Author.When) > a.coalesce {
+		return false
+	}
+
+	// 直前のコミットが今回と同じページだけを変更していること
+	touched, err := a.commitPaths(head)
+	if err != nil {
+		log.Printf("直前のコミットの変更を確認できませんでした: %v", err)
+		return false
+	}
+	if len(touched) == 0 {
+		return false
+	}
+	for _, p := range touched {
+		if !slices.Contains(paths, p) {
+			return false
+		}
+	}
+
+	pushed, err := a.isPushed(head)
+	if err != nil {
+		log.Printf("直前のコミットが push 済みか確認できませんでした: %v", err)
+		return false
+	}
+	if pushed {
+		return false
+	}
+	tagged, err := a.isTagged(head.Hash)
+	if err != nil {
+		log.Printf("直前のコミットのタグを確認できませんでした: %v", err)
+		return false
+	}
+	return !tagged
+}
+
+// commitPaths は commit が親から変更したファイルの一覧を返す。
+func (a *app) commitPaths(commit *object.Commit) ([]string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *object.Tree
+	if commit.NumParents() == 1 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	changes, err := treeChanges(a.repo, parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+	return changesPaths(changes), nil
+}
+
+// isPushed は commit が同期先のリモートに送信済みかを返す。同期していなければ false。
+func (a *app) isPushed(commit *object.Commit) (bool, error) {
+	branch, err := headBranch(a.repo)
+	if err != nil {
+		return false, err
+	}
+	ref, err := a.repo.Reference(plumbing.NewRemoteReferenceName(syncRemoteName, branch.Short()), true)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if ref.Hash() == commit.Hash {
+		return true, nil
+	}
+	remote, err := a.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return false, err
+	}
+	return commit.IsAncestor(remote)
+}
+
+// isTagged は hash を指すタグ (リリース) があるかを返す。
+func (a *app) isTagged(hash plumbing.Hash) (bool, error) {
+	releases, err := a.listReleases()
+	if err != nil {
+		return false, err
+	}
+	for _, rel := range releases {
+		if rel.Hash == hash.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// coalescedMessage はまとめたコミットのメッセージ。最初のメモを件名に残し、
+// 後のメモは同じものを繰り返さずに本文へ追記する。
+func coalescedMessage(previous, message string) string {
+	previous = strings.TrimRight(previous, "\n")
+	lines := strings.Split(previous, "\n")
+	if slices.Contains(lines, message) {
+		return previous
+	}
+	if len(lines) == 1 {
+		return previous + "\n\n" + message
+	}
+	return previous + "\n" + message
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+type commitDetail struct {
+	Hash           string
+	ShortHash      string
+	Subject        string
+	Body           string
+	AuthorName     string
+	AuthorEmail    string
+	AuthorWhen     string
+	CommitterName  string
+	CommitterEmail string
+	CommitterWhen  string
+	Parents        []commitRef
+	Files          []commitFile
+	OtherFiles     []string
+}
+
+type commitRef struct {
+	Hash      string
+	ShortHash string
+	Subject   string
+}
+
+type commitFile struct {
+	Path     string
+	Action   string
+	Title    string
+	Link     string
+	DiffHTML template.HTML
+	Binary   bool
+	Empty    bool
+}
+
+func (a *app) handleCommits(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/commits/"), "/")
+	if rest == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if rev, ok := strings.CutSuffix(rest, ".patch"); ok && rev != "" {
+		a.handleCommitPatch(w, r, rev)
+		return
+	}
+	a.handleCommitDetail(w, r, rest)
+}
+
+// handleCommitDetail は /commits/<hash> で1つのコミットの詳細を表示する。
+// マージコミットの差分は最初の親と比較する。
+func (a *app) handleCommitDetail(w http.ResponseWriter, r *http.Request, rev string) {
+	if a.repo == nil {
+		http.Error(w, "履歴を参照するには Git が必要です。", http.StatusServiceUnavailable)
+		return
+	}
+	commit, err := a.resolveCommit(rev)
+	if err != nil {
+		http.Error(w, "指定の履歴が見つかりません", http.StatusNotFound)
+		return
+	}
+
+	detail, err := a.buildCommitDetail(commit)
+	if err != nil {
+		log.Printf("コミット %s の読み込みに失敗しました: %v", commit.Hash, err)
+		http.Error(w, "履歴の読み込みに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	a.render(w, pageView{
+		Mode:      "commit",
+		SiteTitle: siteTitle,
+		PageTitle: "履歴 " + detail.ShortHash,
+		TOC:       a.currentTOC(),
+		Commit:    detail,
+	})
+}
+
+func (a *app) buildCommitDetail(commit *object.Commit) (*commitDetail, error) {
+	subject, body, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+	if subject == "" {
+		subject = "更新"
+	}
+	hash := commit.Hash.String()
+	detail := &commitDetail{
+		Hash:           hash,
+		ShortHash:      hash[:7],
+		Subject:        subject,
+		Body:           strings.TrimSpace(body),
+		AuthorName:     commit.Author.Name,
+		AuthorEmail:    commit.Author.Email,
+		AuthorWhen:     commit.Author.When.Format("2006-01-02 15:04:05"),
+		CommitterName:  commit.Committer.Name,
+		CommitterEmail: commit.Committer.Email,
+		CommitterWhen:  commit.Committer.When.Format("2006-01-02 15:04:05"),
+	}
+
+	var parent *object.Commit
+	for i, parentHash := range commit.ParentHashes {
+		ref := commitRef{Hash: parentHash.String(), ShortHash: parentHash.String()[:7]}
+		if p, err := a.repo.CommitObject(parentHash); err == nil {
+			ref.Subject = strings.Split(p.Message, "\n")[0]
+			if i == 0 {
+				parent = p
+			}
+		}
+		detail.Parents = append(detail.Parents, ref)
+	}
+
+	var fromTree *object.Tree
+	if parent != nil {
+		tree, err := parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		fromTree = tree
+	}
+	toTree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	// ページ名はそのコミット時点の目次で引き、読めなければ現在の目次を使う
+	pages, _, err := a.loadManualIndexAt(hash)
+	if err != nil {
+		pages, _ = a.manualIndex()
+	}
+	slugByPath := make(map[string]string, len(pages))
+	for slug, meta := range pages {
+		slugByPath[meta.GitPath] = slug
+	}
+
+	manualPrefix, err := computeGitPath(a.projectRoot, a.manualRoot, "")
+	if err != nil {
+		return nil, err
+	}
+	manualPrefix = strings.TrimSuffix(manualPrefix, "/") + "/"
+
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		if !strings.HasPrefix(name, manualPrefix) {
+			detail.OtherFiles = append(detail.OtherFiles, name)
+			continue
+		}
+
+		file, err := buildCommitFile(change, name)
+		if err != nil {
+			return nil, err
+		}
+		if slug, ok := slugByPath[name]; ok {
+			file.Title = pages[slug].Title
+			file.Link = makePageLink(slug) + "?commit=" + hash
+		} else {
+			file.Title = path.Base(name)
+		}
+		detail.Files = append(detail.Files, file)
+	}
+	sort.Slice(detail.Files, func(i, j int) bool { return detail.Files[i].Path < detail.Files[j].Path })
+
+	return detail, nil
+}
+
+func buildCommitFile(change *object.Change, name string) (commitFile, error) {
+	file := commitFile{Path: name}
+
+	action, err := change.Action()
+	if err != nil {
+		return file, err
+	}
+	switch action {
+	case merkletrie.Insert:
+		file.Action = "追加"
+	case merkletrie.Delete:
+		file.Action = "削除"
+	default:
+		file.Action = "変更"
+	}
+
+	from, to, err := change.Files()
+	if err != nil {
+		return file, err
+	}
+	var before, after string
+	if from != nil {
+		if before, err = from.Contents(); err != nil {
+			return file, err
+		}
+	}
+	if to != nil {
+		if after, err = to.Contents(); err != nil {
+			return file, err
+		}
+	}
+	if !utf8.ValidString(before) || !utf8.ValidString(after) {
+		file.Binary = true
+		return file, nil
+	}
+	file.DiffHTML, file.Empty = renderDiff([]byte(before), []byte(after))
+	return file, nil
+}
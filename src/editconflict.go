@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"html/template"
+	"log"
+)
+
+// editConflict は編集画面を開いてから保存するまでの間に、ディスク上のファイルが
+// 他の手段 (テキストエディタや同期) で変更され、自動で統合できなかったことを表す。
+type editConflict struct {
+	// Base は編集画面を開いた時点の内容。履歴から見つからなければ nil。
+	Base []byte
+	Disk []byte
+}
+
+func (c *editConflict) Error() string {
+	return "file changed on disk since the editor was opened"
+}
+
+// contentHash は編集フォームに埋め込む、ファイル内容の SHA-1。
+func contentHash(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// findBaseContent は path の最近の版から内容の SHA-1 が hash に一致するものを探す。
+// ディスク上の変更がまだ記録されていなければ、編集画面を開いた時点の内容は履歴にある。
+func (a *app) findBaseContent(path, hash string) ([]byte, bool) {
+	if a.history == nil {
+		return nil, false
+	}
+	revisions, err := a.history.Log(path, 30)
+	if err != nil {
+		log.Printf("編集前の内容を履歴から探せませんでした: %v", err)
+		return nil, false
+	}
+	for _, rev := range revisions {
+		data, err := a.history.ReadFile(rev.ID, path)
+		if err == nil && contentHash(data) == hash {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// reconcileEdit は保存する内容 submitted をディスク上の現在の内容 disk と突き合わせる。
+// 編集画面を開いた時点 (baseHash) から disk が変わっていなければ submitted をそのまま返す。
+// 変わっていれば行単位の3方向マージを試み、統合できたら merged を true にして結果を返す。
+// 統合できなければ *editConflict を返す。
+func (a *app) reconcileEdit(path, baseHash string, disk, submitted []byte) (content []byte, merged bool, err error) {
+	if baseHash == "" || contentHash(disk) == baseHash || bytes.Equal(disk, submitted) {
+		return submitted, false, nil
+	}
+	base, ok := a.findBaseContent(path, baseHash)
+	if !ok {
+		return nil, false, &editConflict{Disk: disk}
+	}
+	result, ok := mergeLines(base, disk, submitted)
+	if !ok {
+		return nil, false, &editConflict{Base: base, Disk: disk}
+	}
+	return result, true, nil
+}
+
+// conflictDiff は編集の競合画面に表示する差分を返す。編集前の内容がわかれば
+// 編集中に他で加えられた変更を、わからなければ保存しようとした内容との違いを示す。
+func conflictDiff(c *editConflict, submitted []byte) (label string, diff template.HTML) {
+	if c.Base != nil {
+		diff, _ = renderDiff(c.Base, c.Disk)
+		return "編集中にファイルへ加えられた変更", diff
+	}
+	diff, _ = renderDiff(submitted, c.Disk)
+	return "保存しようとした内容から現在のファイルへの差分", diff
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// editorCookie は編集フォームで選んだ編集者をブラウザごとに覚えておく Cookie。
+const editorCookie = "lfwiki_editor"
+
+// editor は manuals/editors.yaml に登録した編集者。
+// ID は Cookie やフォームで使う識別子で、省略するとメールアドレスを使う。
+type editor struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Kana  string `json:"kana"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type editorsFile struct {
+	Editors []editor `json:"editors"`
+}
+
+// Label は選択肢に表示する名前。
+func (e editor) Label() string {
+	if e.Role != "" && !strings.Contains(e.Name, e.Role) {
+		return e.Name + " (" + e.Role + ")"
+	}
+	return e.Name
+}
+
+// loadEditors は editors.yaml を読み込む。ファイルがなければ空の一覧を返す。
+// index.yaml と同じく JSON 形式で記述する。
+func loadEditors(manualRoot string) ([]editor, error) {
+	data, err := os.ReadFile(filepath.Join(manualRoot, "editors.yaml"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var file editorsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	editors := make([]editor, 0, len(file.Editors))
+	seen := make(map[string]bool)
+	for _, e := range file.Editors {
+		e.Name = strings.TrimSpace(e.Name)
+		e.Email = strings.TrimSpace(e.Email)
+		if e.Name == "" {
+			continue
+		}
+		if e.Email == "" {
+			e.Email = makeAuthorEmail(e.Name)
+		}
+		if e.ID == "" {
+			e.ID = e.Email
+		}
+		if seen[e.ID] {
+			log.Printf("editors.yaml に同じ ID の編集者が複数あります: %s", e.ID)
+			continue
+		}
+		seen[e.ID] = true
+		editors = append(editors, e)
+	}
+	sort.SliceStable(editors, func(i, j int) bool {
+		return sortKey(editors[i]) < sortKey(editors[j])
+	})
+	return editors, nil
+}
+
+func sortKey(e editor) string {
+	if e.Kana != "" {
+		return e.Kana
+	}
+	return e.Name
+}
+
+// editors は登録済みの編集者を返す。ファイルを直接書き換えても再起動なしで反映されるよう、
+// 呼ばれるたびに読み込む。
+func (a *app) editors() []editor {
+	editors, err := loadEditors(a.manualRoot)
+	if err != nil {
+		log.Printf("editors.yaml の読み込みに失敗しました: %v", err)
+	}
+	return editors
+}
+
+// lookupEditor は ID または名前が一致する登録済みの編集者を探す。
+func (a *app) lookupEditor(key string) (editor, bool) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return editor{}, false
+	}
+	for _, e := range a.editors() {
+		if e.ID == key || e.Name == key {
+			return e, true
+		}
+	}
+	return editor{}, false
+}
+
+// editorFor は ID または名前に対応する編集者を返す。登録されていない名前なら
+// 名前から作ったメールアドレスを使う。
+func (a *app) editorFor(key string) editor {
+	if e, ok := a.lookupEditor(key); ok {
+		return e
+	}
+	name := strings.TrimSpace(key)
+	return editor{Name: name, Email: makeAuthorEmail(name)}
+}
+
+// editorEmailsMatching は名前・かな・メールアドレス・役割に query を含む
+// 登録済みの編集者のメールアドレスを返す。
+func (a *app) editorEmailsMatching(query string) []string {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	var emails []string
+	for _, e := range a.editors() {
+		for _, field := range []string{e.Name, e.Kana, e.Email, e.Role} {
+			if strings.Contains(strings.ToLower(field), query) {
+				emails = append(emails, strings.ToLower(e.Email))
+				break
+			}
+		}
+	}
+	return emails
+}
+
+// editorFromForm はフォームで選んだ編集者を返す。一覧から選んだ編集者を優先し、
+// 「名前を入力する」のときは入力した名前を使う。
+func (a *app) editorFromForm(r *http.Request) editor {
+	if e, ok := a.lookupEditor(r.PostFormValue("editor")); ok {
+		return e
+	}
+	return a.editorFor(r.PostFormValue("author"))
+}
+
+// rememberEditor は選んだ編集者 (未登録の場合は入力した名前) を Cookie に保存する。
+func rememberEditor(w http.ResponseWriter, e editor) {
+	value := e.ID
+	if value == "" {
+		value = e.Name
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     editorCookie,
+		Value:    url.QueryEscape(value),
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// rememberedEditor は Cookie に保存した編集者を返す。登録済みの編集者でなければ
+// 保存した名前だけを返す。
+func (a *app) rememberedEditor(r *http.Request) (editor, bool) {
+	cookie, err := r.Cookie(editorCookie)
+	if err != nil {
+		return editor{}, false
+	}
+	value, err := url.QueryUnescape(cookie.Value)
+	if err != nil || strings.TrimSpace(value) == "" {
+		return editor{}, false
+	}
+	if e, ok := a.lookupEditor(value); ok {
+		return e, true
+	}
+	return editor{Name: value}, true
+}
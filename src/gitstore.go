@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var errDetachedHead = errors.New("HEAD がブランチを指していません")
+
+// fileChange は1ファイル分の変更。Content が nil の場合は削除を表す。
+type fileChange struct {
+	Path    string
+	Content []byte
+}
+
+// dirtyFilesError は、作業コピーに未コミットの変更があり上書きできないファイルの一覧。
+type dirtyFilesError struct {
+	Paths []string
+}
+
+func (e *dirtyFilesError) Error() string {
+	return "未コミットの変更があるファイル: " + strings.Join(e.Paths, ", ")
+}
+
+// treeFiles はツリー内のファイル (サブツリー以外のエントリ) をパスごとに集める。
+// tree が nil の場合は空の一覧を返す。
+func treeFiles(tree *object.Tree) (map[string]object.TreeEntry, error) {
+	files := make(map[string]object.TreeEntry)
+	if tree == nil {
+		return files, nil
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+		files[name] = entry
+	}
+	return files, nil
+}
+
+// treeChanges は from から to への変更を fileChange の一覧として返す。
+func treeChanges(repo *git.Repository, from, to *object.Tree) ([]fileChange, error) {
+	fromFiles, err := treeFiles(from)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := treeFiles(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []fileChange
+	for p, entry := range toFiles {
+		if old, ok := fromFiles[p]; ok && old.Hash == entry.Hash && old.Mode == entry.Mode {
+			continue
+		}
+		data, err := readBlob(repo, entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, fileChange{Path: p, Content: data})
+	}
+	for p := range fromFiles {
+		if _, ok := toFiles[p]; !ok {
+			changes = append(changes, fileChange{Path: p})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func readBlob(repo *git.Repository, hash plumbing.Hash) ([]byte, error) {
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func writeBlob(s storer.EncodedObjectStorer, data []byte) (plumbing.Hash, error) {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(data)))
+	writer, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := writer.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
+
+// buildTree は base に changes を適用したツリーを書き込み、そのハッシュを返す。
+// インデックスや作業コピーには触れないため、ステージ済みの無関係な変更が
+// コミットに混ざることはない。
+func buildTree(s storer.EncodedObjectStorer, base *object.Tree, changes []fileChange) (plumbing.Hash, error) {
+	files, err := treeFiles(base)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for _, change := range changes {
+		if change.Content == nil {
+			delete(files, change.Path)
+			continue
+		}
+		hash, err := writeBlob(s, change.Content)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		mode := filemode.Regular
+		if old, ok := files[change.Path]; ok && old.Mode == filemode.Executable {
+			mode = old.Mode
+		}
+		files[change.Path] = object.TreeEntry{Mode: mode, Hash: hash}
+	}
+
+	root := &treeNode{}
+	for p, entry := range files {
+		entry.Name = path.Base(p)
+		root.add(strings.Split(p, "/"), entry)
+	}
+	return root.write(s)
+}
+
+type treeNode struct {
+	files []object.TreeEntry
+	dirs  map[string]*treeNode
+}
+
+func (n *treeNode) add(parts []string, entry object.TreeEntry) {
+	if len(parts) == 1 {
+		n.files = append(n.files, entry)
+		return
+	}
+	if n.dirs == nil {
+		n.dirs = make(map[string]*treeNode)
+	}
+	child, ok := n.dirs[parts[0]]
+	if !ok {
+		child = &treeNode{}
+		n.dirs[parts[0]] = child
+	}
+	child.add(parts[1:], entry)
+}
+
+func (n *treeNode) write(s storer.EncodedObjectStorer) (plumbing.Hash, error) {
+	entries := append([]object.TreeEntry(nil), n.files...)
+	for name, child := range n.dirs {
+		hash, err := child.write(s)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+	// Git のツリーはディレクトリ名の末尾に "/" を付けた順で並べる
+	sortKey := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortKey(entries[i]) < sortKey(entries[j]) })
+
+	obj := s.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
+
+func createCommit(s storer.EncodedObjectStorer, tree plumbing.Hash, parents []plumbing.Hash, author, committer object.Signature, message string) (plumbing.Hash, error) {
+	commit := &object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      message,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}
+	obj := s.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
+
+// headBranch は HEAD が指すブランチ名を返す。まだコミットがないブランチでもよい。
+func headBranch(repo *git.Repository) (plumbing.ReferenceName, error) {
+	ref, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+	if ref.Type() != plumbing.SymbolicReference {
+		return "", errDetachedHead
+	}
+	return ref.Target(), nil
+}
+
+// headCommit は HEAD のコミットを返す。まだコミットがない場合は nil を返す。
+func headCommit(repo *git.Repository) (*object.Commit, error) {
+	ref, err := repo.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return repo.CommitObject(ref.Hash())
+}
+
+// updateBranch はブランチが old を指している場合に限り new に進める。
+// old が ZeroHash の場合はブランチがまだ存在しないことを期待する。
+func updateBranch(repo *git.Repository, branch plumbing.ReferenceName, old, new plumbing.Hash) error {
+	var oldRef *plumbing.Reference
+	if !old.IsZero() {
+		oldRef = plumbing.NewHashReference(branch, old)
+	}
+	return repo.Storer.CheckAndSetReference(plumbing.NewHashReference(branch, new), oldRef)
+}
+
+func (a *app) repoAbsPath(gitPath string) string {
+	return filepath.Join(a.projectRoot, filepath.FromSlash(gitPath))
+}
+
+// checkWorktreeClean は changes の対象ファイルが作業コピー上で before と
+// 一致している (未コミットの変更がない) ことを確かめる。
+func (a *app) checkWorktreeClean(before map[string]object.TreeEntry, changes []fileChange) error {
+	var dirty []string
+	for _, change := range changes {
+		data, err := os.ReadFile(a.repoAbsPath(change.Path))
+		entry, tracked := before[change.Path]
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if tracked {
+				dirty = append(dirty, change.Path)
+			}
+		case err != nil:
+			return err
+		case !tracked:
+			// 未追跡のファイルでも、取り込む内容と同じなら上書きしてよい
+			if change.Content == nil || !bytes.Equal(data, change.Content) {
+				dirty = append(dirty, change.Path)
+			}
+		case plumbing.ComputeHash(plumbing.BlobObject, data) != entry.Hash:
+			dirty = append(dirty, change.Path)
+		}
+	}
+	if len(dirty) > 0 {
+		return &dirtyFilesError{Paths: dirty}
+	}
+	return nil
+}
+
+// writeWorktree は changes を作業コピーに書き出し、インデックスも同じ内容に揃える。
+func (a *app) writeWorktree(changes []fileChange) error {
+	for _, change := range changes {
+		abs := a.repoAbsPath(change.Path)
+		if change.Content == nil {
+			if err := os.Remove(abs); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(abs, change.Content, 0o644); err != nil {
+			return err
+		}
+	}
+	return a.stagePaths(changesPaths(changes))
+}
+
+// stagePaths は指定したファイルだけをインデックスに反映する。
+func (a *app) stagePaths(paths []string) error {
+	worktree, err := a.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		err := worktree.AddWithOptions(&git.AddOptions{Path: p, SkipStatus: true})
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return fmt.Errorf("%s をインデックスに反映できません: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func changesPaths(changes []fileChange) []string {
+	paths := make([]string, len(changes))
+	for i, change := range changes {
+		paths[i] = change.Path
+	}
+	return paths
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const historyPageSize = 30
+
+// historyFilter は履歴検索の条件。URL のクエリとそのまま対応するので、
+// 検索結果の URL を共有すれば同じ結果を開ける。
+type historyFilter struct {
+	Author  string
+	Message string
+	Since   string
+	Until   string
+	Page    string
+	PageNum int
+
+	// authorEmails は Author に当てはまる登録済みの編集者のメールアドレス。
+	// かなや役割で検索しても、その人の版が見つかるようにする。
+	authorEmails []string
+}
+
+func historyFilterFromQuery(q url.Values) historyFilter {
+	f := historyFilter{
+		Author:  strings.TrimSpace(q.Get("author")),
+		Message: strings.TrimSpace(q.Get("q")),
+		Since:   strings.TrimSpace(q.Get("since")),
+		Until:   strings.TrimSpace(q.Get("until")),
+		Page:    strings.TrimSpace(q.Get("page")),
+		PageNum: 1,
+	}
+	if n, err := strconv.Atoi(q.Get("p")); err == nil && n > 1 {
+		f.PageNum = n
+	}
+	return f
+}
+
+func (f historyFilter) link(pageNum int) string {
+	q := url.Values{}
+	for key, value := range map[string]string{
+		"author": f.Author,
+		"q":      f.Message,
+		"since":  f.Since,
+		"until":  f.Until,
+		"page":   f.Page,
+	} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if pageNum > 1 {
+		q.Set("p", strconv.Itoa(pageNum))
+	}
+	if len(q) == 0 {
+		return "/history"
+	}
+	return "/history?" + q.Encode()
+}
+
+func (f historyFilter) matches(commit *object.Commit) bool {
+	if f.Author != "" {
+		needle := strings.ToLower(f.Author)
+		if !strings.Contains(strings.ToLower(commit.Author.Name), needle) &&
+			!strings.Contains(strings.ToLower(commit.Author.Email), needle) &&
+			!slices.Contains(f.authorEmails, strings.ToLower(commit.Author.Email)) {
+			return false
+		}
+	}
+	if f.Message != "" && !strings.Contains(strings.ToLower(commit.Message), strings.ToLower(f.Message)) {
+		return false
+	}
+	return true
+}
+
+func (a *app) handleHistory(w http.ResponseWriter, r *http.Request) {
+	filter := historyFilterFromQuery(r.URL.Query())
+	filter.authorEmails = a.editorEmailsMatching(filter.Author)
+
+	view := pageView{
+		Mode:          "history",
+		SiteTitle:     siteTitle,
+		PageTitle:     "履歴の検索",
+		TOC:           a.currentTOC(),
+		HistoryFilter: filter,
+		HistoryPages:  flattenTOC(a.currentTOC()),
+		Editors:       a.editors(),
+	}
+
+	if a.repo == nil {
+		view.Flash = &flashMessage{Type: "error", Message: "履歴を検索するには Git が必要です。"}
+		a.render(w, view)
+		return
+	}
+
+	opts := &git.LogOptions{Order: git.LogOrderCommitterTime}
+	if filter.Page != "" {
+		meta, ok := a.lookupPage(filter.Page)
+		if !ok {
+			view.Flash = &flashMessage{Type: "error", Message: "指定のページが目次に見つかりません。"}
+			a.render(w, view)
+			return
+		}
+		opts.FileName = stringPtr(meta.GitPath)
+	}
+	if filter.Since != "" {
+		since, err := parseRevisionTime(filter.Since, false)
+		if err != nil {
+			view.Flash = &flashMessage{Type: "error", Message: "開始日は 2025-11-01 の形式で指定してください。"}
+			a.render(w, view)
+			return
+		}
+		opts.Since = &since
+	}
+	if filter.Until != "" {
+		until, err := parseRevisionTime(filter.Until, true)
+		if err != nil {
+			view.Flash = &flashMessage{Type: "error", Message: "終了日は 2025-11-30 の形式で指定してください。"}
+			a.render(w, view)
+			return
+		}
+		opts.Until = &until
+	}
+
+	results, hasNext, err := a.searchHistory(opts, filter)
+	if err != nil {
+		log.Printf("履歴の検索に失敗しました: %v", err)
+		view.Flash = &flashMessage{Type: "error", Message: "履歴を検索できませんでした。"}
+	}
+	view.HistoryResults = results
+	if filter.PageNum > 1 {
+		view.HistoryPrevLink = filter.link(filter.PageNum - 1)
+	}
+	if hasNext {
+		view.HistoryNextLink = filter.link(filter.PageNum + 1)
+	}
+
+	a.render(w, view)
+}
+
+// searchHistory は go-git のログを先頭から走査し、条件に合うコミットのうち
+// filter.PageNum ページ目の分だけを返す。
+func (a *app) searchHistory(opts *git.LogOptions, filter historyFilter) ([]historyEntry, bool, error) {
+	iter, err := a.repo.Log(opts)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer iter.Close()
+
+	skip := (filter.PageNum - 1) * historyPageSize
+	var (
+		results []historyEntry
+		hasNext bool
+	)
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if !filter.matches(commit) {
+			return nil
+		}
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		if len(results) == historyPageSize {
+			hasNext = true
+			return storer.ErrStop
+		}
+		results = append(results, a.newHistoryEntry(revisionFromCommit(commit), filter.Page, ""))
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return results, hasNext, err
+	}
+	return results, hasNext, nil
+}
+
+// newHistoryEntry は版を履歴の1項目に変換する。slug が空の場合は
+// 特定のページに絞らない (サイト全体の) 項目として扱う。
+// コミットの詳細やパッチへのリンクは Git で記録している場合だけ付ける。
+func (a *app) newHistoryEntry(rev revision, slug, activeCommit string) historyEntry {
+	message := strings.Split(rev.Message, "\n")[0]
+	if message == "" {
+		message = "更新"
+	}
+
+	entry := historyEntry{
+		Label:       message,
+		Timestamp:   rev.When.Format("2006-01-02 15:04"),
+		Author:      rev.Author,
+		AuthorEmail: strings.ToLower(rev.Email),
+		Hash:        rev.ID,
+		ShortHash:   rev.shortID(),
+		Active:      rev.ID == activeCommit,
+	}
+	if a.repo != nil {
+		entry.CommitLink = "/commits/" + rev.ID
+		entry.CommitPatchLink = "/commits/" + rev.ID + ".patch"
+	}
+	if slug == "" {
+		entry.Link = entry.CommitLink
+		entry.PatchLink = entry.CommitPatchLink
+	} else {
+		entry.Link = makePageLink(slug) + "?commit=" + rev.ID
+		entry.DiffLink = "/diff?page=" + url.QueryEscape(slug) + "&commit=" + rev.ID
+		if a.repo != nil {
+			entry.PatchLink = "/pages/" + url.PathEscape(slug) + "/diff.patch?to=" + rev.ID
+		}
+	}
+	return entry
+}
+
+// flattenTOC は目次を階層順の一覧にする。Title は階層に応じて字下げする。
+func flattenTOC(toc []tocSection) []tocEntry {
+	var result []tocEntry
+	var walk func(entries []tocEntry, depth int)
+	walk = func(entries []tocEntry, depth int) {
+		for _, entry := range entries {
+			result = append(result, tocEntry{
+				Title: strings.Repeat("　", depth) + entry.Title,
+				Slug:  entry.Slug,
+				Href:  entry.Href,
+			})
+			walk(entry.Children, depth+1)
+		}
+	}
+	for _, section := range toc {
+		walk(section.Pages, 0)
+	}
+	return result
+}
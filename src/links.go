@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ページ間のリンクの索引。[[slug]] と /pages/<slug> へのリンク、{{include}} で埋め込んだページを
+// ページごとに集めておき、「このページへのリンク」の一覧に使う。起動時と目次の読み直しで作り直し、
+// 保存したページの分だけ更新する。
+
+// pageContext は slug のページを描画するときの、pages と toc の目次で [[slug]] とマクロを解決する
+// 情報を返す。commitHash が空でなければ、埋め込むページもその版から読む。
+// link は過去の版を表示するときのリンクの置き換え (nil ならそのまま)。toc は置き換え済みのものを渡す。
+func (a *app) pageContext(slug string, pages map[string]pageMeta, toc []tocSection, commitHash string, link func(slug, href string) string) *mdContext {
+	return &mdContext{
+		slug:     slug,
+		now:      time.Now(),
+		shifts:   a.shifts,
+		title:    pages[slug].Title,
+		children: tocChildren(toc, slug),
+		pageLink: func(slug string) (string, string, bool) {
+			meta, ok := pages[slug]
+			if !ok {
+				return "", "", false
+			}
+			href := makePageLink(slug)
+			if link != nil {
+				href = link(slug, href)
+			}
+			return href, meta.Title, true
+		},
+		readPage: func(slug string) (string, error) {
+			meta, ok := pages[slug]
+			if !ok {
+				return "", fmt.Errorf("埋め込むページ「%s」は目次にありません", slug)
+			}
+			data, err := a.readPageSource(meta, commitHash)
+			if err != nil {
+				log.Printf("埋め込むページ %s を読み込めませんでした: %v", slug, err)
+				return "", fmt.Errorf("埋め込むページ「%s」を読み込めませんでした", slug)
+			}
+			return string(data), nil
+		},
+	}
+}
+
+// rebuildLinks は目次のすべてのページを作業コピーから読み、リンクの索引を作り直す。
+func (a *app) rebuildLinks() {
+	pages, _ := a.manualIndex()
+	links := make(map[string][]string, len(pages))
+	includes := make(map[string][]string)
+	for slug, meta := range pages {
+		if targets, included, ok := a.readPageLinks(slug, meta); ok {
+			links[slug] = targets
+			if len(included) > 0 {
+				includes[slug] = included
+			}
+		}
+	}
+	a.linksMu.Lock()
+	a.links = links
+	a.includes = includes
+	a.linksMu.Unlock()
+}
+
+// refreshLinks は paths (リポジトリ相対パス) に当たるページの索引だけを更新する。
+func (a *app) refreshLinks(paths []string) {
+	pages, _ := a.manualIndex()
+	for slug, meta := range pages {
+		if !slices.Contains(paths, meta.GitPath) {
+			continue
+		}
+		targets, included, ok := a.readPageLinks(slug, meta)
+		a.linksMu.Lock()
+		if a.links == nil {
+			a.links = make(map[string][]string)
+			a.includes = make(map[string][]string)
+		}
+		if ok {
+			a.links[slug] = targets
+		} else {
+			delete(a.links, slug)
+		}
+		if len(included) > 0 {
+			a.includes[slug] = included
+		} else {
+			delete(a.includes, slug)
+		}
+		a.linksMu.Unlock()
+	}
+}
+
+func (a *app) readPageLinks(slug string, meta pageMeta) (links, includes []string, ok bool) {
+	data, err := os.ReadFile(a.manualAbsPath(meta.RelFile))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("ページ %s のリンクを読み取れませんでした: %v", slug, err)
+		}
+		return nil, nil, false
+	}
+	links, includes = pageLinks(string(data))
+	return links, includes, true
+}
+
+// backlinks は slug のページへリンクしているページを、タイトル順に返す。
+func (a *app) backlinks(slug string) []tocEntry {
+	pages, _ := a.manualIndex()
+	a.linksMu.RLock()
+	defer a.linksMu.RUnlock()
+	return referrers(pages, a.links, slug)
+}
+
+// includedBy は slug のページを {{include}} で埋め込んでいるページを、タイトル順に返す。
+func (a *app) includedBy(slug string) []tocEntry {
+	pages, _ := a.manualIndex()
+	a.linksMu.RLock()
+	defer a.linksMu.RUnlock()
+	return referrers(pages, a.includes, slug)
+}
+
+// referrers は index (ページごとの参照先) で slug を参照しているページを返す。
+func referrers(pages map[string]pageMeta, index map[string][]string, slug string) []tocEntry {
+	var entries []tocEntry
+	for source, targets := range index {
+		meta, ok := pages[source]
+		if source == slug || !ok || !slices.Contains(targets, slug) {
+			continue
+		}
+		entries = append(entries, tocEntry{Title: meta.Title, Slug: source, Href: makePageLink(source)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Title != entries[j].Title {
+			return entries[i].Title < entries[j].Title
+		}
+		return entries[i].Slug < entries[j].Slug
+	})
+	return entries
+}
+
+// markdownRef はページの中のリンク・画像・[[slug]]・マクロ。コードの中に書いたものは含まない。
+type markdownRef struct {
+	kind  mdInlineKind // inLink, inImage, inWikiLink, inMacro
+	dest  string       // リンク先。マクロは名前
+	arg   string       // マクロの引数
+	block bool         // 1行に単独で書いたマクロ
+	line  int
+}
+
+// markdownRefs は Markdown を解析して、リンクとマクロを出てくる順に返す。
+func markdownRefs(md string) []markdownRef {
+	doc, refs := parseMarkdown(md)
+	var found []markdownRef
+	var walkInline func(n *mdInline, line *int)
+	walkInline = func(n *mdInline, line *int) {
+		for c := n.first; c != nil; c = c.next {
+			switch c.kind {
+			case inSoftBreak, inLineBreak:
+				*line++
+			case inLink, inImage, inWikiLink:
+				found = append(found, markdownRef{kind: c.kind, dest: c.dest, line: *line})
+			case inMacro:
+				found = append(found, markdownRef{kind: inMacro, dest: c.dest, arg: c.title, line: *line})
+			}
+			walkInline(c, line)
+		}
+	}
+	inline := func(s string, line int) {
+		walkInline(parseInline(s, refs), &line)
+	}
+	var walk func(n *mdNode)
+	walk = func(n *mdNode) {
+		if name, arg, ok := blockMacro(n); ok {
+			found = append(found, markdownRef{kind: inMacro, dest: name, arg: arg, block: true, line: n.startLine})
+			return
+		}
+		switch n.kind {
+		case mdParagraph, mdHeading:
+			inline(n.content, n.startLine)
+		case mdCallout:
+			inline(n.callout.title, n.startLine)
+		case mdTable:
+			// 見出し行の次は区切りの行
+			for _, cell := range n.table.header {
+				inline(cell, n.startLine)
+			}
+			for i, row := range n.table.rows {
+				for _, cell := range row {
+					inline(cell, n.startLine+2+i)
+				}
+			}
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return found
+}
+
+// pageLinks は Markdown の中のサイト内のページへのリンク先と、{{include}} で埋め込むページ (slug) を
+// それぞれ重複なく返す。
+func pageLinks(md string) (links, includes []string) {
+	for _, ref := range markdownRefs(md) {
+		switch ref.kind {
+		case inWikiLink:
+			if slug, _, _ := strings.Cut(ref.dest, "#"); slug != "" {
+				links = append(links, slug)
+			}
+		case inLink:
+			if slug, ok := pageSlugFromHref(ref.dest); ok {
+				links = append(links, slug)
+			}
+		case inMacro:
+			if slug, _, _ := strings.Cut(strings.TrimSpace(ref.arg), "#"); ref.block && ref.dest == "include" && slug != "" {
+				includes = append(includes, slug)
+			}
+		}
+	}
+	sort.Strings(links)
+	sort.Strings(includes)
+	return slices.Compact(links), slices.Compact(includes)
+}
+
+// pageSlugFromHref は / と /pages/<slug> へのリンクからページの slug を取り出す。
+func pageSlugFromHref(href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", false
+	}
+	if u.Path == "/" {
+		return "top", true
+	}
+	slug, ok := strings.CutPrefix(u.Path, "/pages/")
+	if !ok {
+		return "", false
+	}
+	slug, _, _ = strings.Cut(strings.Trim(slug, "/"), "/")
+	return slug, slug != ""
+}
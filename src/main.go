@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"html"
 	"html/template"
@@ -11,49 +14,85 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 const siteTitle = "社内マニュアル"
 
 var (
-	errNoChanges = errors.New("no changes to commit")
-	errNoRepo    = errors.New("git repository not available")
+	errNoChanges   = errors.New("no changes to commit")
+	errNoRepo      = errors.New("git repository not available")
+	errInvalidTime = errors.New("invalid time format")
 )
 
 type app struct {
 	projectRoot string
 	manualRoot  string
-	topRelFile  string
-	topGitPath  string
-	pages       map[string]pageMeta
-	toc         []tocSection
 	tmpl        *template.Template
 	repo        *git.Repository
+	history     historyStore
+	sync        *manualSync
+	writes      *writeQueue
+	// coalesce は同じ編集者の連続した保存を直前のコミットにまとめる時間。0 ならまとめない。
+	// lastEdit は編集画面からの保存で作った最後のコミットで、まとめる先になれるのはこれだけ。a.writes の中で読み書きする。
+	// メモリにだけ持つので、起動し直した後の最初の保存は必ず新しい版になる。
+	coalesce time.Duration
+	lastEdit plumbing.Hash
+	// shifts はチェックリストの状態を分けるシフトの区切り。
+	shifts     shiftClock
+	checklists *checklistStore
+	// renders は作業コピーのページを描画した結果のキャッシュ。
+	renders *renderCache
+
+	indexMu sync.RWMutex
+	pages   map[string]pageMeta
+	toc     []tocSection
+
+	// links はページの slug ごとの、そのページからリンクしているページの slug。
+	// includes は同じく {{include}} で埋め込んでいるページの slug。
+	linksMu  sync.RWMutex
+	links    map[string][]string
+	includes map[string][]string
 }
 
 type historyEntry struct {
-	Label     string
-	Link      string
-	Timestamp string
-	Hash      string
-	Active    bool
+	Label           string
+	Link            string
+	DiffLink        string
+	PatchLink       string
+	CommitLink      string
+	CommitPatchLink string
+	Timestamp       string
+	Author          string
+	AuthorEmail     string
+	Hash            string
+	ShortHash       string
+	Active          bool
 }
 
 type manualPage struct {
 	Title     string
 	Content   template.HTML
+	Outline   []outlineEntry
+	Tasks     []taskItem
 	UpdatedAt time.Time
+	// Volatile は時刻で変わるマクロを使っていて、表示する時刻によって内容が変わるページ。
+	Volatile bool
 }
 
 type flashMessage struct {
@@ -62,23 +101,49 @@ type flashMessage struct {
 }
 
 type pageView struct {
-	Mode             string
-	SiteTitle        string
-	PageTitle        string
-	Content          template.HTML
-	UpdatedAt        string
-	History          []historyEntry
-	Flash            *flashMessage
-	EditContent      string
-	EditAuthor       string
-	EditMessage      string
-	DiffTitle        string
-	DiffBaseLabel    string
-	DiffCompareLabel string
-	DiffHTML         template.HTML
-	DiffIsEmpty      bool
-	TOC              []tocSection
-	CanEdit          bool
+	Mode              string
+	Slug              string
+	SiteTitle         string
+	PageTitle         string
+	Content           template.HTML
+	Outline           []outlineEntry
+	UpdatedAt         string
+	History           []historyEntry
+	Flash             *flashMessage
+	EditContent       string
+	EditBase          string
+	EditConflictLabel string
+	EditConflictHTML  template.HTML
+	EditAuthor        string
+	EditEditor        string
+	Editors           []editor
+	Changes           []workingChange
+	EditMessage       string
+	DiffTitle         string
+	DiffBaseLabel     string
+	DiffCompareLabel  string
+	DiffHTML          template.HTML
+	DiffIsEmpty       bool
+	DiffCommit        string
+	DiffCommitLink    string
+	DiffPage          string
+	DiffView          string
+	TOC               []tocSection
+	CanEdit           bool
+	HistoryFilter     historyFilter
+	HistoryResults    []historyEntry
+	HistoryPages      []tocEntry
+	HistoryPrevLink   string
+	HistoryNextLink   string
+	Commit            *commitDetail
+	Sync              *syncStatus
+	SyncConflicts     []syncConflictView
+	Releases          []release
+	ReleaseForm       releaseForm
+	Changelog         *releaseChangelog
+	Backlinks         []tocEntry
+	IncludedBy        []tocEntry
+	Checklist         *checklistView
 }
 
 type tocSection struct {
@@ -103,6 +168,7 @@ type indexFile struct {
 	Title       string          `json:"title" yaml:"title"`
 	Description string          `json:"description" yaml:"description"`
 	Categories  []indexCategory `json:"categories" yaml:"categories"`
+	Pages       []indexPage     `json:"pages" yaml:"pages"`
 }
 
 type indexCategory struct {
@@ -116,63 +182,175 @@ type indexPage struct {
 	Title    string      `json:"title" yaml:"title"`
 	File     string      `json:"file" yaml:"file"`
 	Children []indexPage `json:"children" yaml:"children"`
+	Pages    []indexPage `json:"pages" yaml:"pages"`
+}
+
+// childPages は children と pages のどちらで書かれた子ページも返す。
+func (p indexPage) childPages() []indexPage {
+	if len(p.Pages) == 0 {
+		return p.Children
+	}
+	children := make([]indexPage, 0, len(p.Children)+len(p.Pages))
+	children = append(children, p.Children...)
+	return append(children, p.Pages...)
 }
 
 func main() {
+	remoteURL := flag.String("remote", os.Getenv("LFWIKI_REMOTE"), "同期先の Git リモート (URL またはベアリポジトリのパス)")
+	syncInterval := flag.Duration("sync-interval", time.Minute, "リモートと同期する間隔")
+	pushOnCommit := flag.Bool("push", true, "同期時にこの PC の変更をリモートへ push する")
+	coalesce := flag.Duration("coalesce", 0, "同じ編集者が同じページを続けて保存したとき、この時間内なら直前の版にまとめる (0 でまとめない)")
+	historyBackend := flag.String("history", "auto", "履歴の保存先 (auto: Git が使えなければファイル, file: 常にファイル)")
+	dayShift := flag.String("day-shift", "09:00", "日勤が始まる時刻 (チェックリストの状態はシフトごとに分けて記録する)")
+	nightShift := flag.String("night-shift", "19:00", "夜勤が始まる時刻")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `使い方: %s [オプション] [コマンド]
+
+コマンド:
+  serve              マニュアルを http://localhost:8080/ で提供する (既定)
+  import-revisions   ファイルに記録した版を Git に取り込む
+  tag list           リリース (タグ) の一覧を表示する
+  tag create         リリースを作成する (tag create -h で詳細)
+  tag changelog      2つのリリースの間の変更点を表示する
+  check [ファイル...] 保存前と同じチェックを作業コピーに対して行う
+
+オプション:
+`, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	app, err := newApp(*historyBackend)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.coalesce = *coalesce
+	if app.shifts, err = parseShiftClock(*dayShift, *nightShift); err != nil {
+		log.Fatal(err)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "", "serve":
+		app.serve(*remoteURL, *syncInterval, *pushOnCommit)
+	case "import-revisions":
+		if err := importRevisions(app.projectRoot); err != nil {
+			log.Fatalf("版の取り込みに失敗しました: %v", err)
+		}
+	case "tag":
+		if err := app.runTagCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+	case "check":
+		if err := app.runCheckCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// newApp はマニュアルのディレクトリ・テンプレート・目次・履歴の保存先を読み込む。
+func newApp(historyBackend string) (*app, error) {
 	manualRoot, err := findManualRoot()
 	if err != nil {
-		log.Fatalf("マニュアルディレクトリが見つかりません: %v", err)
+		return nil, fmt.Errorf("マニュアルディレクトリが見つかりません: %w", err)
 	}
 
 	projectRoot := filepath.Dir(manualRoot)
 
 	tmpl, err := template.ParseFiles(filepath.Join(projectRoot, "web", "templates", "page.html"))
 	if err != nil {
-		log.Fatalf("テンプレートの読み込みに失敗しました: %v", err)
+		return nil, fmt.Errorf("テンプレートの読み込みに失敗しました: %w", err)
 	}
 
-	repo, err := git.PlainOpenWithOptions(projectRoot, &git.PlainOpenOptions{DetectDotGit: true})
-	if err != nil {
-		if errors.Is(err, git.ErrRepositoryNotExists) {
-			repo, err = git.PlainInit(projectRoot, false)
-			if err != nil {
-				log.Printf("Git リポジトリの初期化に失敗: %v", err)
+	var repo *git.Repository
+	if historyBackend != "file" {
+		repo, err = git.PlainOpenWithOptions(projectRoot, &git.PlainOpenOptions{DetectDotGit: true})
+		if err != nil {
+			if errors.Is(err, git.ErrRepositoryNotExists) {
+				repo, err = git.PlainInit(projectRoot, false)
+				if err != nil {
+					log.Printf("Git リポジトリの初期化に失敗: %v", err)
+				} else {
+					log.Printf("Git リポジトリを初期化しました: %s", projectRoot)
+				}
 			} else {
-				log.Printf("Git リポジトリを初期化しました: %s", projectRoot)
+				log.Printf("Git リポジトリを開けません: %v", err)
 			}
-		} else {
-			log.Printf("Git リポジトリを開けません: %v", err)
 		}
 	}
 
 	pageMap, toc, err := loadManualIndex(projectRoot, manualRoot)
 	if err != nil {
-		log.Fatalf("index.yaml の読み込みに失敗しました: %v", err)
+		return nil, fmt.Errorf("index.yaml の読み込みに失敗しました: %w", err)
 	}
 
-	topMeta, ok := pageMap["top"]
-	if !ok {
-		log.Fatalf("index.yaml にトップページ (slug: top) が定義されていません")
+	if _, ok := pageMap["top"]; !ok {
+		return nil, fmt.Errorf("index.yaml にトップページ (slug: top) が定義されていません")
 	}
 
 	app := &app{
 		projectRoot: projectRoot,
 		manualRoot:  manualRoot,
-		topRelFile:  topMeta.RelFile,
-		topGitPath:  topMeta.GitPath,
 		pages:       pageMap,
 		toc:         toc,
 		tmpl:        tmpl,
 		repo:        repo,
+		writes:      newWriteQueue(),
+		shifts:      defaultShiftClock,
+		checklists:  newChecklistStore(projectRoot),
+		renders:     newRenderCache(),
+	}
+
+	if app.repo != nil {
+		app.history = gitHistory{app: app}
+	} else if store, err := newFileHistory(projectRoot); err != nil {
+		log.Printf("履歴の保存先を用意できません: %v", err)
+	} else {
+		app.history = store
+		log.Printf("Git を使わず %s に履歴を記録します", revisionDir)
+	}
+
+	return app, nil
+}
+
+func (a *app) serve(remoteURL string, syncInterval time.Duration, push bool) {
+	if a.repo != nil {
+		if n := pendingRevisions(a.projectRoot); n > 0 {
+			log.Printf("Git に取り込まれていない版が %d 件あります。`go run . import-revisions` で取り込めます", n)
+		}
+	}
+
+	if remoteURL != "" {
+		if a.repo == nil {
+			log.Printf("Git リポジトリがないため同期は無効です")
+		} else {
+			a.sync = newManualSync(a, remoteURL, syncInterval, push)
+			go a.sync.run()
+		}
 	}
 
+	a.rebuildLinks()
+
 	mux := http.NewServeMux()
-	staticDir := http.Dir(filepath.Join(projectRoot, "web", "static"))
+	staticDir := http.Dir(filepath.Join(a.projectRoot, "web", "static"))
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(staticDir)))
-	mux.HandleFunc("/", app.handleManual)
-	mux.HandleFunc("/pages/", app.handlePage)
-	mux.HandleFunc("/edit", app.handleEdit)
-	mux.HandleFunc("/diff", app.handleDiff)
+	mux.HandleFunc("/", a.handleManual)
+	mux.HandleFunc("/pages/", a.handlePage)
+	mux.HandleFunc("/edit", a.handleEdit)
+	mux.HandleFunc("/diff", a.handleDiff)
+	mux.HandleFunc("/history", a.handleHistory)
+	mux.HandleFunc("/commits/", a.handleCommits)
+	mux.HandleFunc("/sync", a.handleSync)
+	mux.HandleFunc("/revert", a.handleRevert)
+	mux.HandleFunc("/changes", a.handleChanges)
+	mux.HandleFunc("/releases", a.handleReleases)
+	mux.HandleFunc("/releases/changelog", a.handleReleaseChangelog)
+	mux.HandleFunc("/at/", a.handleAt)
+	mux.HandleFunc("/checklist", a.handleChecklist)
+	mux.HandleFunc("/debug/render-cache", a.handleRenderCache)
 
 	addr := ":8080"
 	log.Printf("マニュアルを http://localhost%s/ で提供中…", addr)
@@ -187,36 +365,11 @@ func (a *app) handleManual(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	commitHash := strings.TrimSpace(r.URL.Query().Get("commit"))
-
-	page, err := a.loadManualPage(a.topRelFile, a.topGitPath, commitHash)
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, plumbing.ErrObjectNotFound) {
-			http.Error(w, "指定の履歴が見つかりません", http.StatusNotFound)
-			return
-		}
-		log.Printf("マニュアルの読み込みに失敗しました: %v", err)
-		http.Error(w, "マニュアルの読み込みに失敗しました", http.StatusInternalServerError)
+	view, ok := a.buildPageView(w, r, "top")
+	if !ok {
 		return
 	}
-
-	view := pageView{
-		Mode:      "view",
-		SiteTitle: siteTitle,
-		PageTitle: page.Title,
-		Content:   page.Content,
-		UpdatedAt: page.UpdatedAt.Format("2006-01-02 15:04"),
-		History:   a.buildHistory(commitHash),
-		TOC:       a.toc,
-		CanEdit:   true,
-	}
-
-	if r.URL.Query().Get("saved") == "1" {
-		view.Flash = &flashMessage{
-			Type:    "success",
-			Message: "マニュアルを保存し、履歴に記録しました。",
-		}
-	}
+	view.Mode = "view"
 
 	a.render(w, view)
 }
@@ -231,56 +384,277 @@ func (a *app) handlePage(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if pageSlug, ok := strings.CutSuffix(slug, "/diff.patch"); ok {
+		a.handlePagePatch(w, r, pageSlug)
+		return
+	}
 	if slug == "top" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		target := "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
 		return
 	}
-	meta, ok := a.pages[slug]
+
+	view, ok := a.buildPageView(w, r, slug)
 	if !ok {
-		http.NotFound(w, r)
 		return
 	}
+	view.Mode = "page"
+
+	a.render(w, view)
+}
 
-	page, err := a.loadManualPage(meta.RelFile, meta.GitPath, "")
+// buildPageView は ?commit= / ?at= で指定された時点のページと目次を読み込む。
+// 指定がなければ作業コピーを表示する。
+func (a *app) buildPageView(w http.ResponseWriter, r *http.Request, slug string) (pageView, bool) {
+	commitHash, err := a.resolveRevision(r.URL.Query())
 	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidTime):
+			http.Error(w, "日時は 2025-11-01T09:00 の形式で指定してください", http.StatusBadRequest)
+		case errors.Is(err, errNoRepo):
+			http.Error(w, "履歴を参照するには Git が必要です。", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "指定の履歴が見つかりません", http.StatusNotFound)
+		}
+		return pageView{}, false
+	}
+
+	return a.buildPageViewAt(w, r, slug, commitHash, func(_, href string) string {
+		return href + "?commit=" + commitHash
+	})
+}
+
+// buildPageViewAt は commitHash の版のページを読み込む。commitHash が空なら作業コピーを使う。
+// 過去の版を表示するときは、目次のリンクを link で置き換えて同じ版のまま移動できるようにする。
+func (a *app) buildPageViewAt(w http.ResponseWriter, r *http.Request, slug, commitHash string, link func(slug, href string) string) (pageView, bool) {
+	var err error
+	pages, toc := a.manualIndex()
+	var pageLink func(slug, href string) string
+	if commitHash != "" {
+		pageLink = link
+		pages, toc, err = a.loadManualIndexAt(commitHash)
+		if errors.Is(err, fs.ErrNotExist) {
+			// 目次が版に記録されていない場合は現在の目次で代用する
+			pages, toc = a.manualIndex()
+			err = nil
+		}
+		if err != nil {
+			log.Printf("履歴 %s の目次を読み込めませんでした: %v", commitHash, err)
+			http.Error(w, "指定の履歴の目次を読み込めませんでした", http.StatusNotFound)
+			return pageView{}, false
+		}
+		toc = tocWithLinks(toc, link)
+	}
+
+	meta, ok := pages[slug]
+	if !ok {
+		http.NotFound(w, r)
+		return pageView{}, false
+	}
+
+	page, err := a.loadManualPage(meta.RelFile, meta.GitPath, commitHash, a.pageContext(slug, pages, toc, commitHash, pageLink))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, plumbing.ErrObjectNotFound) || errors.Is(err, object.ErrFileNotFound) {
+			http.Error(w, "指定の履歴にこのページは存在しません", http.StatusNotFound)
+			return pageView{}, false
+		}
 		log.Printf("ページ %s の読み込みに失敗しました: %v", slug, err)
 		http.Error(w, "ページを読み込めませんでした", http.StatusInternalServerError)
-		return
+		return pageView{}, false
+	}
+
+	title := meta.Title
+	if slug == "top" {
+		title = page.Title
 	}
 
 	view := pageView{
-		Mode:      "page",
+		Slug:      slug,
 		SiteTitle: siteTitle,
-		PageTitle: meta.Title,
+		PageTitle: title,
 		Content:   page.Content,
+		Outline:   page.Outline,
 		UpdatedAt: page.UpdatedAt.Format("2006-01-02 15:04"),
-		TOC:       a.toc,
+		History:   a.buildHistory(slug, meta, commitHash),
+		TOC:       toc,
+		CanEdit:   slug == "top" && commitHash == "",
+	}
+	if commitHash == "" {
+		view.Backlinks = a.backlinks(slug)
+		view.IncludedBy = a.includedBy(slug)
+		if view.Checklist = a.pageChecklist(slug, meta, page.Tasks); view.Checklist != nil {
+			view.Editors = a.editors()
+			if who, ok := a.rememberedEditor(r); ok {
+				view.EditEditor = who.ID
+				view.EditAuthor = who.Name
+			}
+		}
+	}
+	if commitHash != "" {
+		view.Flash = &flashMessage{
+			Type:    "info",
+			Message: fmt.Sprintf("%s 時点の内容を表示しています。", page.UpdatedAt.Format("2006-01-02 15:04")),
+		}
+	}
+	if commitHash == "" && r.URL.Query().Get("saved") == "1" {
+		view.Flash = savedFlash(r.URL.Query())
+	}
+	if commitHash == "" && r.URL.Query().Get("checklist") == "reset" {
+		view.Flash = &flashMessage{Type: "success", Message: "このシフトのチェックリストをリセットしました。"}
 	}
 
-	a.render(w, view)
+	return view, true
+}
+
+// resolveRevision は ?commit= (ハッシュ・短縮ハッシュ・参照名・版 ID) または
+// ?at= (日時) から表示する版を決める。どちらもなければ空文字を返す。
+func (a *app) resolveRevision(q url.Values) (string, error) {
+	rev := strings.TrimSpace(q.Get("commit"))
+	at := strings.TrimSpace(q.Get("at"))
+	if rev == "" && at == "" {
+		return "", nil
+	}
+	if a.history == nil {
+		return "", errNoRepo
+	}
+
+	if rev != "" {
+		found, err := a.history.Resolve(rev)
+		if err != nil {
+			return "", err
+		}
+		return found.ID, nil
+	}
+
+	until, err := parseRevisionTime(at, true)
+	if err != nil {
+		return "", err
+	}
+	found, err := a.history.At(until)
+	if err != nil {
+		return "", err
+	}
+	return found.ID, nil
+}
+
+var revisionTimeLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseRevisionTime は日時を解釈する。日付のみの場合、endOfDay なら
+// その日の終わりまでを含める。
+func parseRevisionTime(value string, endOfDay bool) (time.Time, error) {
+	for _, layout := range revisionTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if layout == "2006-01-02" && endOfDay {
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errInvalidTime
+}
+
+// tocWithLinks は目次の各リンクを link(slug, href) の結果に置き換える。
+func tocWithLinks(toc []tocSection, link func(slug, href string) string) []tocSection {
+	result := make([]tocSection, len(toc))
+	for i, section := range toc {
+		result[i] = tocSection{
+			Title: section.Title,
+			Pages: tocEntriesWithLinks(section.Pages, link),
+		}
+	}
+	return result
+}
+
+// tocChildren は目次で slug のページのすぐ下にあるページを返す。トップページは目次の各区分の
+// 最初の階層のページを返す。
+func tocChildren(toc []tocSection, slug string) []tocEntry {
+	var find func(entries []tocEntry) ([]tocEntry, bool)
+	find = func(entries []tocEntry) ([]tocEntry, bool) {
+		for _, entry := range entries {
+			if entry.Slug == slug {
+				return entry.Children, true
+			}
+			if children, ok := find(entry.Children); ok {
+				return children, true
+			}
+		}
+		return nil, false
+	}
+	var top []tocEntry
+	for _, section := range toc {
+		if children, ok := find(section.Pages); ok {
+			return children
+		}
+		top = append(top, section.Pages...)
+	}
+	if slug == "top" {
+		return top
+	}
+	return nil
+}
+
+func tocEntriesWithLinks(entries []tocEntry, link func(slug, href string) string) []tocEntry {
+	result := make([]tocEntry, len(entries))
+	for i, entry := range entries {
+		entry.Href = link(entry.Slug, entry.Href)
+		entry.Children = tocEntriesWithLinks(entry.Children, link)
+		result[i] = entry
+	}
+	return result
+}
+
+// editPageView はトップページの編集画面を組み立てる。who.ID が空なら未登録の名前として扱う。
+// base は編集を始めた時点のファイル内容の SHA-1。
+func (a *app) editPageView(top pageMeta, content, base string, who editor, message string, flash *flashMessage) pageView {
+	return pageView{
+		Mode:        "edit",
+		SiteTitle:   siteTitle,
+		PageTitle:   "トップページを編集",
+		History:     a.buildHistory("top", top, ""),
+		TOC:         a.currentTOC(),
+		Flash:       flash,
+		Editors:     a.editors(),
+		EditContent: content,
+		EditBase:    base,
+		EditEditor:  who.ID,
+		EditAuthor:  who.Name,
+		EditMessage: message,
+	}
+}
+
+// editSave は書き込みキューで保存した結果。
+type editSave struct {
+	result   commitResult
+	merged   bool
+	conflict *editConflict
+	saveErr  error
 }
 
 func (a *app) handleEdit(w http.ResponseWriter, r *http.Request) {
+	top, _ := a.lookupPage("top")
+
 	switch r.Method {
 	case http.MethodGet:
-		content, err := os.ReadFile(a.manualAbsPath(a.topRelFile))
+		content, err := os.ReadFile(a.manualAbsPath(top.RelFile))
 		if err != nil {
 			log.Printf("マニュアルの読み込みに失敗しました: %v", err)
 			http.Error(w, "マニュアルを読み込めませんでした", http.StatusInternalServerError)
 			return
 		}
 
-		view := pageView{
-			Mode:        "edit",
-			SiteTitle:   siteTitle,
-			PageTitle:   "トップページを編集",
-			History:     a.buildHistory(""),
-			TOC:         a.toc,
-			EditContent: string(content),
-			EditAuthor:  "マニュアル編集者",
+		who, ok := a.rememberedEditor(r)
+		if !ok {
+			who = editor{Name: "マニュアル編集者"}
 		}
-
-		a.render(w, view)
+		a.render(w, a.editPageView(top, string(content), contentHash(content), who, "", nil))
 
 	case http.MethodPost:
 		if err := r.ParseForm(); err != nil {
@@ -288,55 +662,84 @@ func (a *app) handleEdit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		content := strings.TrimRight(r.PostFormValue("content"), "\r\n")
-		author := strings.TrimSpace(r.PostFormValue("author"))
+		content := strings.TrimRight(strings.ReplaceAll(r.PostFormValue("content"), "\r\n", "\n"), "\n")
+		base := strings.TrimSpace(r.PostFormValue("base"))
 		message := strings.TrimSpace(r.PostFormValue("message"))
 
+		who := a.editorFromForm(r)
+
 		if content == "" {
-			a.render(w, pageView{
-				Mode:        "edit",
-				SiteTitle:   siteTitle,
-				PageTitle:   "トップページを編集",
-				History:     a.buildHistory(""),
-				EditContent: "",
-				EditAuthor:  author,
-				EditMessage: message,
-				Flash: &flashMessage{
-					Type:    "error",
-					Message: "内容が空のため保存できません。",
-				},
-			})
+			a.render(w, a.editPageView(top, "", base, who, message, &flashMessage{
+				Type:    "error",
+				Message: "内容が空のため保存できません。",
+			}))
 			return
 		}
 
-		if author == "" {
-			author = "マニュアル編集者"
+		if who.Name == "" {
+			who = a.editorFor("マニュアル編集者")
+		} else {
+			rememberEditor(w, who)
 		}
 		if message == "" {
 			message = "マニュアル更新"
 		}
 
-		filePath := a.manualAbsPath(a.topRelFile)
-		if err := os.WriteFile(filePath, []byte(content+"\n"), 0o644); err != nil {
-			log.Printf("マニュアルの保存に失敗しました: %v", err)
-			a.render(w, pageView{
-				Mode:        "edit",
-				SiteTitle:   siteTitle,
-				PageTitle:   "トップページを編集",
-				History:     a.buildHistory(""),
-				TOC:         a.toc,
-				EditContent: content,
-				EditAuthor:  author,
-				EditMessage: message,
-				Flash: &flashMessage{
-					Type:    "error",
-					Message: "ファイルの保存に失敗しました。",
-				},
+		// ファイルの書き込みからコミットまでを1つの操作として書き込みキューで実行する
+		// 編集画面を開いてからディスク上のファイルが変わっていれば、統合できる場合だけ保存する
+		saved, err := queueWrite(r.Context(), a.writes, func() (editSave, error) {
+			filePath := a.manualAbsPath(top.RelFile)
+			disk, err := os.ReadFile(filePath)
+			existed := err == nil
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return editSave{saveErr: err}, err
+			}
+			data, merged, err := a.reconcileEdit(top.GitPath, base, disk, []byte(content+"\n"))
+			var conflict *editConflict
+			if errors.As(err, &conflict) {
+				return editSave{conflict: conflict}, err
+			}
+			if err := os.WriteFile(filePath, data, 0o644); err != nil {
+				return editSave{saveErr: err}, err
+			}
+			// 編集画面からの保存だけは、続けて保存した版を直前の版にまとめてよい
+			result, err := a.commitManual(who, message, true, top.GitPath)
+			var invalid *validationError
+			if errors.As(err, &invalid) {
+				// チェックで止めた内容はファイルにも残さない
+				if err := restoreFile(filePath, disk, existed); err != nil {
+					log.Printf("保存前の内容に戻せませんでした: %v", err)
+				}
+			}
+			return editSave{result: result, merged: merged}, err
+		})
+		if conflict := saved.conflict; conflict != nil {
+			view := a.editPageView(top, content, contentHash(conflict.Disk), who, message, &flashMessage{
+				Type:    "error",
+				Message: "編集中に他の人がこのページのファイルを変更したため、保存していません。下の変更を確認し、必要なら本文に反映してから保存し直してください (そのまま保存すると現在のファイルを上書きします)。",
 			})
+			view.EditConflictLabel, view.EditConflictHTML = conflictDiff(conflict, []byte(content+"\n"))
+			a.render(w, view)
+			return
+		}
+		if saved.saveErr != nil {
+			log.Printf("マニュアルの保存に失敗しました: %v", saved.saveErr)
+			a.render(w, a.editPageView(top, content, base, who, message, &flashMessage{
+				Type:    "error",
+				Message: "ファイルの保存に失敗しました。",
+			}))
+			return
+		}
+
+		if note, ok := validationNote(err); ok {
+			a.render(w, a.editPageView(top, content, base, who, message, &flashMessage{
+				Type:    "error",
+				Message: note,
+			}))
 			return
 		}
 
-		if err := a.commitManual(author, message); err != nil {
+		if err != nil {
 			log.Printf("コミット処理に失敗しました: %v", err)
 			var note string
 			switch {
@@ -344,27 +747,23 @@ func (a *app) handleEdit(w http.ResponseWriter, r *http.Request) {
 				note = "Git が設定されていないため履歴に残せませんでした。`git init` を実行してから再度お試しください。"
 			case errors.Is(err, errNoChanges):
 				note = "内容に変更がないため、履歴は追加されませんでした。"
+			case errors.Is(err, errWriteTimeout):
+				note = "他の保存や同期の処理が混み合っているため、保存できませんでした。しばらくしてから再度お試しください。"
 			default:
 				note = "履歴への記録に失敗しました。Git の設定を確認してください。"
 			}
-			a.render(w, pageView{
-				Mode:        "edit",
-				SiteTitle:   siteTitle,
-				PageTitle:   "トップページを編集",
-				History:     a.buildHistory(""),
-				TOC:         a.toc,
-				EditContent: content,
-				EditAuthor:  author,
-				EditMessage: message,
-				Flash: &flashMessage{
-					Type:    "error",
-					Message: note,
-				},
-			})
+			a.render(w, a.editPageView(top, content, base, who, message, &flashMessage{
+				Type:    "error",
+				Message: note,
+			}))
 			return
 		}
 
-		http.Redirect(w, r, "/?saved=1", http.StatusSeeOther)
+		target := savedRedirect("/", saved.result)
+		if saved.merged {
+			target += "&merged=1"
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
 
 	default:
 		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
@@ -373,12 +772,33 @@ func (a *app) handleEdit(w http.ResponseWriter, r *http.Request) {
 
 func (a *app) handleDiff(w http.ResponseWriter, r *http.Request) {
 	commitHash := strings.TrimSpace(r.URL.Query().Get("commit"))
+	diffView := strings.TrimSpace(r.URL.Query().Get("view"))
+	if diffView != "rendered" {
+		diffView = "source"
+	}
+	slug := strings.TrimSpace(r.URL.Query().Get("page"))
+	if slug == "" {
+		slug = "top"
+	}
+	meta, ok := a.lookupPage(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 
-	if a.repo == nil {
-		http.Error(w, "差分を表示するには Git が必要です。", http.StatusServiceUnavailable)
+	if a.history == nil {
+		http.Error(w, "差分を表示するには履歴の保存先が必要です。", http.StatusServiceUnavailable)
 		return
 	}
 
+	if view, ok := a.buildDiffView(w, r, slug, meta, commitHash, diffView); ok {
+		a.render(w, view)
+	}
+}
+
+// buildDiffView は commitHash の版 (空なら最新の版) と作業コピーの差分ビューを組み立てる。
+// 組み立てられなかった場合はエラーを書き込み、false を返す。
+func (a *app) buildDiffView(w http.ResponseWriter, r *http.Request, slug string, meta pageMeta, commitHash, diffView string) (pageView, bool) {
 	var (
 		baseContent    []byte
 		compareContent []byte
@@ -389,274 +809,322 @@ func (a *app) handleDiff(w http.ResponseWriter, r *http.Request) {
 		err            error
 	)
 
-	workingPath := a.manualAbsPath(a.topRelFile)
+	workingPath := a.manualAbsPath(meta.RelFile)
 	compareContent, err = os.ReadFile(workingPath)
 	if err != nil {
 		log.Printf("作業コピーの読み込みに失敗しました: %v", err)
 		http.Error(w, "作業コピーを読み込めませんでした", http.StatusInternalServerError)
-		return
+		return pageView{}, false
 	}
 
 	if commitHash == "" {
-		headRef, err := a.repo.Head()
+		head, err := a.history.Head()
 		if err != nil {
+			if !errors.Is(err, errNoRevisions) {
+				log.Printf("最新の版を取得できませんでした: %v", err)
+			}
 			view := pageView{
 				Mode:             "diff",
 				SiteTitle:        siteTitle,
 				PageTitle:        "差分ビュー",
-				History:          a.buildHistory(""),
+				History:          a.buildHistory(slug, meta, ""),
 				DiffTitle:        "差分はまだありません",
 				DiffBaseLabel:    "まだコミットがありません",
 				DiffCompareLabel: "最新 (作業コピー)",
 				DiffIsEmpty:      true,
+				DiffPage:         slug,
+				DiffView:         diffView,
 				Flash: &flashMessage{
 					Type:    "error",
 					Message: "保存済みの履歴がまだないため、差分を表示できません。",
 				},
 			}
-			a.render(w, view)
-			return
-		}
-		commit, err := a.repo.CommitObject(headRef.Hash())
-		if err != nil {
-			http.Error(w, "履歴の読み込みに失敗しました", http.StatusInternalServerError)
-			return
+			return view, true
 		}
 
-		file, err := commit.File(a.topGitPath)
+		baseContent, err = a.history.ReadFile(head.ID, meta.GitPath)
 		if err != nil {
 			http.Error(w, "比較対象のファイルが見つかりません", http.StatusNotFound)
-			return
+			return pageView{}, false
 		}
-		reader, err := file.Reader()
-		if err != nil {
-			http.Error(w, "履歴の読み込みに失敗しました", http.StatusInternalServerError)
-			return
-		}
-		defer reader.Close()
 
-		baseContent, err = io.ReadAll(reader)
-		if err != nil {
-			http.Error(w, "履歴の読み込みに失敗しました", http.StatusInternalServerError)
-			return
-		}
-
-		baseLabel = fmt.Sprintf("最新コミット (%s)", commit.Author.When.Format("2006-01-02 15:04"))
+		baseLabel = fmt.Sprintf("最新コミット (%s)", head.When.Format("2006-01-02 15:04"))
 		compareLabel = "最新 (作業コピー)"
 		diffTitle = "最新コミットと作業コピーの差分"
 		activeCommit = ""
 	} else {
-		hash := plumbing.NewHash(commitHash)
-		commit, err := a.repo.CommitObject(hash)
+		rev, err := a.history.Resolve(commitHash)
 		if err != nil {
 			http.Error(w, "指定の履歴が見つかりません", http.StatusNotFound)
-			return
-		}
-
-		file, err := commit.File(a.topGitPath)
-		if err != nil {
-			http.Error(w, "履歴のファイルが見つかりません", http.StatusNotFound)
-			return
+			return pageView{}, false
 		}
-		reader, err := file.Reader()
-		if err != nil {
-			http.Error(w, "履歴のファイルが読み込めません", http.StatusInternalServerError)
-			return
-		}
-		defer reader.Close()
 
-		baseContent, err = io.ReadAll(reader)
+		baseContent, err = a.history.ReadFile(rev.ID, meta.GitPath)
 		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, object.ErrFileNotFound) {
+				http.Error(w, "履歴のファイルが見つかりません", http.StatusNotFound)
+				return pageView{}, false
+			}
 			http.Error(w, "履歴のファイルが読み込めません", http.StatusInternalServerError)
-			return
+			return pageView{}, false
 		}
 
-		message := strings.Split(commit.Message, "\n")[0]
+		message := strings.Split(rev.Message, "\n")[0]
 		if message == "" {
 			message = "更新"
 		}
-		baseLabel = fmt.Sprintf("%s (%s)", message, commit.Author.When.Format("2006-01-02 15:04"))
+		baseLabel = fmt.Sprintf("%s (%s)", message, rev.When.Format("2006-01-02 15:04"))
 		compareLabel = "最新 (作業コピー)"
 		diffTitle = "選択した履歴と最新の差分"
-		activeCommit = commitHash
+		activeCommit = rev.ID
 	}
 
-	diffHTML, empty := renderDiff(baseContent, compareContent)
+	var (
+		diffHTML template.HTML
+		empty    bool
+	)
+	if diffView == "rendered" {
+		pages, toc := a.manualIndex()
+		diffHTML, empty = renderRenderedDiff(baseContent, compareContent, a.pageContext(slug, pages, toc, "", nil))
+	} else {
+		diffHTML, empty = renderDiff(baseContent, compareContent)
+	}
 
 	view := pageView{
 		Mode:             "diff",
 		SiteTitle:        siteTitle,
 		PageTitle:        "差分ビュー",
-		History:          a.buildHistory(activeCommit),
+		History:          a.buildHistory(slug, meta, activeCommit),
 		DiffTitle:        diffTitle,
 		DiffBaseLabel:    baseLabel,
 		DiffCompareLabel: compareLabel,
 		DiffHTML:         diffHTML,
 		DiffIsEmpty:      empty,
-		TOC:              a.toc,
+		Slug:             slug,
+		DiffCommit:       activeCommit,
+		DiffPage:         slug,
+		DiffView:         diffView,
+		TOC:              a.currentTOC(),
+	}
+	if activeCommit != "" && a.repo != nil {
+		view.DiffCommitLink = "/commits/" + activeCommit
+	}
+	if activeCommit != "" {
+		// この版に戻すときの編集者は、編集画面と同じく登録済みの一覧から選ぶ
+		who, ok := a.rememberedEditor(r)
+		if !ok {
+			who = editor{Name: "マニュアル編集者"}
+		}
+		view.Editors = a.editors()
+		view.EditEditor = who.ID
+		view.EditAuthor = who.Name
 	}
 
-	a.render(w, view)
+	return view, true
 }
 
-func (a *app) loadManualPage(relPath, gitPath, commitHash string) (manualPage, error) {
+func (a *app) loadManualPage(relPath, gitPath, commitHash string, ctx *mdContext) (manualPage, error) {
 	normalized := filepath.ToSlash(relPath)
 	if normalized == "" {
 		return manualPage{}, fmt.Errorf("読み込むファイルパスが指定されていません")
 	}
 
 	if commitHash == "" {
-		return loadManualFromFile(a.manualAbsPath(normalized))
+		return a.renders.load(a.manualAbsPath(normalized), ctx)
 	}
-	if a.repo == nil {
-		return manualPage{}, fmt.Errorf("履歴を参照するにはGitリポジトリが必要です")
+	if a.history == nil {
+		return manualPage{}, errNoRepo
 	}
 	if gitPath == "" {
 		return manualPage{}, fmt.Errorf("履歴参照用のファイルパスが指定されていません")
 	}
 
-	hash := plumbing.NewHash(commitHash)
-	commit, err := a.repo.CommitObject(hash)
+	rev, err := a.history.Resolve(commitHash)
 	if err != nil {
 		return manualPage{}, err
 	}
-
-	file, err := commit.File(gitPath)
+	data, err := a.history.ReadFile(rev.ID, gitPath)
 	if err != nil {
 		return manualPage{}, err
 	}
 
-	reader, err := file.Reader()
+	return manualPageFromMarkdown(data, rev.When, ctx), nil
+}
+
+// readPageSource は作業コピー (commitHash が空のとき) または指定の版のページの Markdown を返す。
+func (a *app) readPageSource(meta pageMeta, commitHash string) ([]byte, error) {
+	if commitHash == "" {
+		return os.ReadFile(a.manualAbsPath(meta.RelFile))
+	}
+	if a.history == nil {
+		return nil, errNoRepo
+	}
+	rev, err := a.history.Resolve(commitHash)
 	if err != nil {
-		return manualPage{}, err
+		return nil, err
+	}
+	return a.history.ReadFile(rev.ID, meta.GitPath)
+}
+
+func readCommitFile(commit *object.Commit, gitPath string) ([]byte, error) {
+	file, err := commit.File(gitPath)
+	if err != nil {
+		return nil, err
 	}
-	defer reader.Close()
 
-	data, err := io.ReadAll(reader)
+	reader, err := file.Reader()
 	if err != nil {
-		return manualPage{}, err
+		return nil, err
 	}
+	defer reader.Close()
 
-	return manualPageFromMarkdown(data, commit.Author.When), nil
+	return io.ReadAll(reader)
 }
 
-func (a *app) buildHistory(activeCommit string) []historyEntry {
+func (a *app) buildHistory(slug string, meta pageMeta, activeCommit string) []historyEntry {
 	workingCopyTime := time.Now()
-	if info, err := os.Stat(a.manualAbsPath(a.topRelFile)); err == nil {
+	if info, err := os.Stat(a.manualAbsPath(meta.RelFile)); err == nil {
 		workingCopyTime = info.ModTime()
 	}
 
 	history := []historyEntry{
 		{
 			Label:     "最新 (作業コピー)",
-			Link:      "/",
+			Link:      makePageLink(slug),
 			Timestamp: workingCopyTime.Format("2006-01-02 15:04"),
 			Active:    activeCommit == "",
 			Hash:      "",
 		},
 	}
 
-	if a.repo == nil {
+	if a.history == nil {
 		return history
 	}
 
-	iter, err := a.repo.Log(&git.LogOptions{FileName: stringPtr(a.topGitPath)})
+	revisions, err := a.history.Log(meta.GitPath, 30)
 	if err != nil {
-		if !errors.Is(err, plumbing.ErrObjectNotFound) && !errors.Is(err, plumbing.ErrReferenceNotFound) {
-			log.Printf("履歴を取得できませんでした: %v", err)
-		}
-		return history
+		log.Printf("履歴を取得できませんでした: %v", err)
+	}
+	for _, rev := range revisions {
+		history = append(history, a.newHistoryEntry(rev, slug, activeCommit))
 	}
-	defer iter.Close()
 
-	count := 0
-	err = iter.ForEach(func(commit *object.Commit) error {
-		if count >= 30 {
-			return storer.ErrStop
-		}
-		count++
+	return history
+}
 
-		message := strings.Split(commit.Message, "\n")[0]
-		if message == "" {
-			message = "更新"
-		}
-		hash := commit.Hash.String()
-		history = append(history, historyEntry{
-			Label:     message,
-			Link:      "/?commit=" + hash,
-			Timestamp: commit.Author.When.Format("2006-01-02 15:04"),
-			Hash:      hash,
-			Active:    hash == activeCommit,
-		})
-		return nil
-	})
+// commitResult は commitManual の結果。
+type commitResult struct {
+	// Unrelated は今回の版に含めなかった未コミットのファイル。
+	Unrelated []string
+	// Warnings は保存前のチェックで見つかった警告。
+	Warnings []validationIssue
+}
 
-	if err != nil && !errors.Is(err, storer.ErrStop) {
-		log.Printf("履歴の走査に失敗しました: %v", err)
+// commitManual は paths に挙げたファイル (リポジトリ相対パス) の作業コピーの内容だけを
+// 1つの版として記録する。インデックスにステージ済みの他の変更は含めない。
+// 記録する前に validators でチェックし、エラーがあれば *validationError を返して記録しない。
+// coalesce は historyStore.Commit と同じ。作業コピーと参照を書き換えるので、a.writes の中から呼ぶ。
+func (a *app) commitManual(author editor, message string, coalesce bool, paths ...string) (commitResult, error) {
+	if a.history == nil {
+		return commitResult{}, errNoRepo
 	}
-
-	return history
+	warnings, err := a.validatePaths(paths)
+	if err != nil {
+		return commitResult{Warnings: warnings}, err
+	}
+	unrelated, err := a.history.Commit(author.Name, author.Email, message, paths, coalesce)
+	if err == nil {
+		a.refreshLinks(paths)
+		a.forgetRendered(paths)
+	}
+	if err == nil && a.sync != nil {
+		a.sync.trigger()
+	}
+	return commitResult{Unrelated: unrelated, Warnings: warnings}, err
 }
 
-func (a *app) commitManual(author, message string) error {
-	if a.repo == nil {
-		return errNoRepo
+// restoreFile は書き換える前のファイルの内容に戻す。existed が false なら書き換える前はなかったので削除する。
+func restoreFile(path string, data []byte, existed bool) error {
+	if !existed {
+		return os.Remove(path)
 	}
+	return os.WriteFile(path, data, 0o644)
+}
 
+// unrelatedChanges は paths 以外で未コミットの変更があるファイルを返す。
+func (a *app) unrelatedChanges(paths []string) ([]string, error) {
 	worktree, err := a.repo.Worktree()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	status, err := worktree.Status()
 	if err != nil {
-		return err
-	}
-	if status.IsClean() {
-		return errNoChanges
+		return nil, err
 	}
 
-	stageManual := func(path string) error {
-		return worktree.AddWithOptions(&git.AddOptions{
-			Path:       path,
-			SkipStatus: true,
-		})
+	touched := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		touched[p] = true
 	}
-
-	stageErr := stageManual(a.topGitPath)
-	if stageErr != nil {
-		if alt := filepath.FromSlash(a.topGitPath); alt != a.topGitPath {
-			if err := stageManual(alt); err == nil {
-				stageErr = nil
-			} else {
-				stageErr = err
-			}
+	var unrelated []string
+	for p, fs := range status {
+		if touched[p] || (fs.Staging == git.Unmodified && fs.Worktree == git.Unmodified) {
+			continue
 		}
+		unrelated = append(unrelated, p)
 	}
+	sort.Strings(unrelated)
+	return unrelated, nil
+}
 
-	if stageErr != nil {
-		if err := worktree.AddWithOptions(&git.AddOptions{
-			Glob:       "manuals/**",
-			SkipStatus: true,
-		}); err != nil {
-			if err := worktree.AddWithOptions(&git.AddOptions{All: true}); err != nil {
-				return fmt.Errorf("ステージングに失敗しました: %w", stageErr)
-			}
-		}
+const maxSkippedInURL = 10
+
+// savedRedirect は保存後の移動先 URL を作る。今回の履歴に含めなかった
+// 未コミットのファイルや保存前のチェックの警告があれば、画面で知らせるためにクエリに載せる。
+func savedRedirect(target string, result commitResult) string {
+	q := url.Values{"saved": {"1"}}
+	if unrelated := result.Unrelated; len(unrelated) > 0 {
+		q["skipped"] = unrelated[:min(len(unrelated), maxSkippedInURL)]
+		q.Set("skipped_total", strconv.Itoa(len(unrelated)))
 	}
+	for _, issue := range result.Warnings[:min(len(result.Warnings), maxSkippedInURL)] {
+		q.Add("warning", issue.String())
+	}
+	return target + "?" + q.Encode()
+}
 
-	now := time.Now()
-	signature := &object.Signature{
-		Name:  author,
-		Email: makeAuthorEmail(author),
-		When:  now,
+func savedFlash(q url.Values) *flashMessage {
+	flash := &flashMessage{
+		Type:    "success",
+		Message: "マニュアルを保存し、履歴に記録しました。",
+	}
+	if q.Get("merged") == "1" {
+		flash.Message += "編集中に他で加えられた変更と統合して保存しました。"
 	}
+	if skipped := q["skipped"]; len(skipped) > 0 {
+		list := strings.Join(skipped, ", ")
+		if total, err := strconv.Atoi(q.Get("skipped_total")); err == nil && total > len(skipped) {
+			list += fmt.Sprintf(" ほか %d 件", total-len(skipped))
+		}
+		flash.Message += "次のファイルは未コミットのまま残っています (今回の履歴には含めていません): " + list
+	}
+	if warnings := q["warning"]; len(warnings) > 0 {
+		flash.Message += "確認をおすすめする箇所があります: " + strings.Join(warnings, " / ")
+	}
+	return flash
+}
 
-	_, err = worktree.Commit(message, &git.CommitOptions{
-		Author:    signature,
-		Committer: signature,
-	})
-	return err
+// validationNote は保存前のチェックで保存を止めたときに表示する文。
+func validationNote(err error) (string, bool) {
+	var invalid *validationError
+	if !errors.As(err, &invalid) {
+		return "", false
+	}
+	issues := make([]string, len(invalid.Issues))
+	for i, issue := range invalid.Issues {
+		issues[i] = issue.String()
+	}
+	return "保存前のチェックで問題が見つかったため保存していません: " + strings.Join(issues, " / "), true
 }
 
 func renderDiff(base, compare []byte) (template.HTML, bool) {
@@ -698,129 +1166,213 @@ func renderDiff(base, compare []byte) (template.HTML, bool) {
 	return template.HTML(b.String()), !hasChanges
 }
 
-func loadManualFromFile(path string) (manualPage, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return manualPage{}, err
-	}
-	info, err := os.Stat(path)
-	if err != nil {
-		return manualPage{}, err
-	}
-	return manualPageFromMarkdown(data, info.ModTime()), nil
-}
-
-func manualPageFromMarkdown(data []byte, updatedAt time.Time) manualPage {
-	htmlBody := markdownToHTML(string(data))
-
-	title := extractTitle(htmlBody)
-	if title == "" {
-		title = "トップページ"
+// renderRenderedDiff は両方の版を HTML に変換したうえで差分を取り、
+// 整形済みの文書の中に <ins>/<del> で変更箇所を示す。
+func renderRenderedDiff(base, compare []byte, ctx *mdContext) (template.HTML, bool) {
+	if bytes.Equal(base, compare) {
+		return "", true
 	}
 
-	return manualPage{
-		Title:     title,
-		Content:   htmlBody,
-		UpdatedAt: updatedAt,
+	baseTokens := tokenizeHTML(string(markdownToHTML(string(base), ctx)))
+	compareTokens := tokenizeHTML(string(markdownToHTML(string(compare), ctx)))
+
+	// トークン単位で比較するため、各トークンを1文字に割り当てる
+	dict := make(map[string]rune)
+	var table []string
+	encode := func(tokens []string) []rune {
+		runes := make([]rune, len(tokens))
+		for i, tok := range tokens {
+			r, ok := dict[tok]
+			if !ok {
+				r = rune(len(table) + 1)
+				if r >= 0xD800 {
+					r += 0x800
+				}
+				dict[tok] = r
+				table = append(table, tok)
+			}
+			runes[i] = r
+		}
+		return runes
+	}
+	decode := func(text string) []string {
+		var tokens []string
+		for _, r := range text {
+			idx := int(r)
+			if idx >= 0xE000 {
+				idx -= 0x800
+			}
+			tokens = append(tokens, table[idx-1])
+		}
+		return tokens
 	}
-}
 
-func extractTitle(content template.HTML) string {
-	re := regexp.MustCompile(`<h1>(.*?)</h1>`)
-	match := re.FindStringSubmatch(string(content))
-	if len(match) < 2 {
-		return ""
-	}
-	return html.UnescapeString(match[1])
-}
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(encode(baseTokens), encode(compareTokens), false)
+	diffs = dmp.DiffCleanupSemantic(diffs)
 
-func markdownToHTML(md string) template.HTML {
-	lines := strings.Split(md, "\n")
 	var b strings.Builder
+	hasChanges := false
 
-	writeParagraph := func(text string) {
-		if text == "" {
-			return
+	for _, diff := range diffs {
+		tokens := decode(diff.Text)
+		switch diff.Type {
+		case diffmatchpatch.DiffInsert:
+			hasChanges = true
+			writeMarkedTokens(&b, tokens, "ins", true)
+		case diffmatchpatch.DiffDelete:
+			hasChanges = true
+			// 削除側のタグは、対応が取れている場合だけ残して構造を壊さない
+			writeMarkedTokens(&b, tokens, "del", tagsBalanced(tokens))
+		default:
+			for _, tok := range tokens {
+				b.WriteString(tok)
+			}
 		}
-		b.WriteString("<p>")
-		b.WriteString(html.EscapeString(text))
-		b.WriteString("</p>")
 	}
 
-	var (
-		inUL bool
-		inOL bool
-	)
+	return template.HTML(b.String()), !hasChanges
+}
 
-	closeLists := func() {
-		if inUL {
-			b.WriteString("</ul>")
-			inUL = false
+func writeMarkedTokens(b *strings.Builder, tokens []string, mark string, keepTags bool) {
+	open := false
+	for _, tok := range tokens {
+		if strings.HasPrefix(tok, "<") {
+			if open {
+				b.WriteString("</" + mark + ">")
+				open = false
+			}
+			if keepTags {
+				b.WriteString(tok)
+			}
+			continue
 		}
-		if inOL {
-			b.WriteString("</ol>")
-			inOL = false
+		if !open {
+			b.WriteString("<" + mark + ` class="diff__` + mark + `">`)
+			open = true
 		}
+		b.WriteString(tok)
+	}
+	if open {
+		b.WriteString("</" + mark + ">")
 	}
+}
 
-	numbered := regexp.MustCompile(`^\d+\.\s+`)
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true, "input": true}
 
-	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
+func tagsBalanced(tokens []string) bool {
+	depth := 0
+	for _, tok := range tokens {
+		if !strings.HasPrefix(tok, "<") {
+			continue
+		}
+		name := strings.TrimLeft(tok, "</")
+		if i := strings.IndexAny(name, " \t\n/>"); i >= 0 {
+			name = name[:i]
+		}
 		switch {
-		case strings.HasPrefix(line, "### "):
-			closeLists()
-			b.WriteString("<h3>")
-			b.WriteString(html.EscapeString(strings.TrimPrefix(line, "### ")))
-			b.WriteString("</h3>")
-		case strings.HasPrefix(line, "## "):
-			closeLists()
-			b.WriteString("<h2>")
-			b.WriteString(html.EscapeString(strings.TrimPrefix(line, "## ")))
-			b.WriteString("</h2>")
-		case strings.HasPrefix(line, "# "):
-			closeLists()
-			b.WriteString("<h1>")
-			b.WriteString(html.EscapeString(strings.TrimPrefix(line, "# ")))
-			b.WriteString("</h1>")
-		case strings.HasPrefix(line, "- "):
-			if inOL {
-				b.WriteString("</ol>")
-				inOL = false
+		case voidElements[strings.ToLower(name)]:
+		case strings.HasPrefix(tok, "</"):
+			depth--
+			if depth < 0 {
+				return false
 			}
-			if !inUL {
-				b.WriteString("<ul>")
-				inUL = true
+		default:
+			depth++
+		}
+	}
+	return depth == 0
+}
+
+// tokenizeHTML はタグ・文字参照・英数字の語・空白をそれぞれ1トークンとし、
+// それ以外 (日本語など) は1文字ずつに分割する。
+func tokenizeHTML(s string) []string {
+	var tokens []string
+	for len(s) > 0 {
+		var n int
+		switch c := s[0]; {
+		case c == '<':
+			n = strings.IndexByte(s, '>') + 1
+		case c == '&':
+			n = strings.IndexByte(s, ';') + 1
+			if n > 12 {
+				n = 0
 			}
-			b.WriteString("<li>")
-			b.WriteString(html.EscapeString(strings.TrimPrefix(line, "- ")))
-			b.WriteString("</li>")
-		case numbered.MatchString(line):
-			if inUL {
-				b.WriteString("</ul>")
-				inUL = false
+		case isWordByte(c):
+			for n < len(s) && isWordByte(s[n]) {
+				n++
 			}
-			if !inOL {
-				b.WriteString("<ol>")
-				inOL = true
+		case c == ' ' || c == '\t' || c == '\n':
+			for n < len(s) && (s[n] == ' ' || s[n] == '\t' || s[n] == '\n') {
+				n++
 			}
-			item := numbered.ReplaceAllString(line, "")
-			b.WriteString("<li>")
-			b.WriteString(html.EscapeString(item))
-			b.WriteString("</li>")
-		case line == "":
-			closeLists()
-		default:
-			closeLists()
-			writeParagraph(line)
+		}
+		if n <= 0 {
+			_, n = utf8.DecodeRuneInString(s)
+		}
+		tokens = append(tokens, s[:n])
+		s = s[n:]
+	}
+	return tokens
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func manualPageFromMarkdown(data []byte, updatedAt time.Time, ctx *mdContext) manualPage {
+	if ctx != nil {
+		// {{updated}} はこのページの更新日時
+		pageCtx := *ctx
+		pageCtx.updated = updatedAt
+		ctx = &pageCtx
+	}
+	rendered := renderMarkdown(string(data), ctx)
+
+	title := extractTitle(rendered.HTML)
+	if title == "" {
+		title = "トップページ"
+	}
+
+	return manualPage{
+		Title:     title,
+		Content:   rendered.HTML,
+		Outline:   pageOutline(rendered.Headings),
+		Tasks:     rendered.Tasks,
+		UpdatedAt: updatedAt,
+		Volatile:  rendered.Volatile,
+	}
+}
+
+// pageOutline はページ内の目次に載せる見出し (h2 と h3) を返す。見出しが1つ以下なら目次は出さない。
+func pageOutline(headings []outlineEntry) []outlineEntry {
+	var outline []outlineEntry
+	for _, h := range headings {
+		if h.Level == 2 || h.Level == 3 {
+			outline = append(outline, h)
 		}
 	}
-	closeLists()
+	if len(outline) < 2 {
+		return nil
+	}
+	return outline
+}
 
-	return template.HTML(b.String())
+var reTitleHeading = regexp.MustCompile(`<h1[^>]*>(.*?)</h1>`)
+
+func extractTitle(content template.HTML) string {
+	match := reTitleHeading.FindStringSubmatch(string(content))
+	if len(match) < 2 {
+		return ""
+	}
+	return html.UnescapeString(stripTags(match[1]))
 }
 
 func (a *app) render(w http.ResponseWriter, view pageView) {
+	if a.sync != nil {
+		status := a.sync.status()
+		view.Sync = &status
+	}
 	if err := a.tmpl.Execute(w, view); err != nil {
 		log.Printf("テンプレート描画に失敗しました: %v", err)
 		http.Error(w, "内部エラー", http.StatusInternalServerError)
@@ -876,28 +1428,68 @@ func makeAuthorEmail(name string) string {
 	if normalized == "" {
 		normalized = "editor"
 	}
+	// 日本語の名前などは英数字がほとんど残らず全員が同じアドレスになってしまうため、
+	// 名前のハッシュを付けて人ごとに区別できるようにする。
+	if hasNonASCII(name) {
+		sum := sha1.Sum([]byte(name))
+		normalized += "." + hex.EncodeToString(sum[:4])
+	}
 	return normalized + "@manual.local"
 }
 
+func hasNonASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return true
+		}
+	}
+	return false
+}
+
 func loadManualIndex(projectRoot, manualRoot string) (map[string]pageMeta, []tocSection, error) {
 	indexPath := filepath.Join(manualRoot, "index.yaml")
 	data, err := os.ReadFile(indexPath)
 	if err != nil {
 		return nil, nil, err
 	}
+	return parseManualIndex(data, projectRoot, manualRoot, true)
+}
 
+// loadManualIndexAt は指定した版の時点の index.yaml から目次を組み立てる。
+func (a *app) loadManualIndexAt(commitHash string) (map[string]pageMeta, []tocSection, error) {
+	if a.history == nil {
+		return nil, nil, errNoRepo
+	}
+	indexGitPath, err := computeGitPath(a.projectRoot, a.manualRoot, "index.yaml")
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := a.history.ReadFile(commitHash, indexGitPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseManualIndex(data, a.projectRoot, a.manualRoot, false)
+}
+
+func parseManualIndex(data []byte, projectRoot, manualRoot string, checkFiles bool) (map[string]pageMeta, []tocSection, error) {
 	var idx indexFile
 	if err := json.Unmarshal(data, &idx); err != nil {
 		return nil, nil, err
 	}
 
+	categories := idx.Categories
+	if len(categories) == 0 && len(idx.Pages) > 0 {
+		// カテゴリを持たない形式では、ページの木全体を1つのグループとして扱う
+		categories = []indexCategory{{Title: idx.Title, Pages: idx.Pages}}
+	}
+
 	slugMap := make(map[string]pageMeta)
-	toc := make([]tocSection, 0, len(idx.Categories))
-	for _, cat := range idx.Categories {
+	toc := make([]tocSection, 0, len(categories))
+	for _, cat := range categories {
 		if len(cat.Pages) == 0 {
 			continue
 		}
-		entries, err := convertIndexPages(cat.Pages, slugMap, projectRoot, manualRoot)
+		entries, err := convertIndexPages(cat.Pages, slugMap, projectRoot, manualRoot, checkFiles)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -910,7 +1502,7 @@ func loadManualIndex(projectRoot, manualRoot string) (map[string]pageMeta, []toc
 	return slugMap, toc, nil
 }
 
-func convertIndexPages(pages []indexPage, slugMap map[string]pageMeta, projectRoot, manualRoot string) ([]tocEntry, error) {
+func convertIndexPages(pages []indexPage, slugMap map[string]pageMeta, projectRoot, manualRoot string, checkFiles bool) ([]tocEntry, error) {
 	result := make([]tocEntry, 0, len(pages))
 	for _, p := range pages {
 		if p.Slug == "" {
@@ -932,9 +1524,11 @@ func convertIndexPages(pages []indexPage, slugMap map[string]pageMeta, projectRo
 			return nil, err
 		}
 
-		absPath := filepath.Join(manualRoot, filepath.FromSlash(relFile))
-		if _, err := os.Stat(absPath); err != nil {
-			log.Printf("警告: 目次で参照しているファイル %s の確認に失敗しました: %v", absPath, err)
+		if checkFiles {
+			absPath := filepath.Join(manualRoot, filepath.FromSlash(relFile))
+			if _, err := os.Stat(absPath); err != nil {
+				log.Printf("警告: 目次で参照しているファイル %s の確認に失敗しました: %v", absPath, err)
+			}
 		}
 
 		slugMap[p.Slug] = pageMeta{
@@ -943,7 +1537,7 @@ func convertIndexPages(pages []indexPage, slugMap map[string]pageMeta, projectRo
 			GitPath: gitPath,
 		}
 
-		children, err := convertIndexPages(p.Children, slugMap, projectRoot, manualRoot)
+		children, err := convertIndexPages(p.childPages(), slugMap, projectRoot, manualRoot, checkFiles)
 		if err != nil {
 			return nil, err
 		}
@@ -974,6 +1568,44 @@ func makePageLink(slug string) string {
 	return "/pages/" + slug
 }
 
+func (a *app) manualIndex() (map[string]pageMeta, []tocSection) {
+	a.indexMu.RLock()
+	defer a.indexMu.RUnlock()
+	return a.pages, a.toc
+}
+
+func (a *app) lookupPage(slug string) (pageMeta, bool) {
+	a.indexMu.RLock()
+	defer a.indexMu.RUnlock()
+	meta, ok := a.pages[slug]
+	return meta, ok
+}
+
+func (a *app) currentTOC() []tocSection {
+	a.indexMu.RLock()
+	defer a.indexMu.RUnlock()
+	return a.toc
+}
+
+// reloadIndex は index.yaml を読み直す。読み込みに失敗した場合は現在の目次を維持する。
+func (a *app) reloadIndex() error {
+	pageMap, toc, err := loadManualIndex(a.projectRoot, a.manualRoot)
+	if err != nil {
+		return err
+	}
+	if _, ok := pageMap["top"]; !ok {
+		return fmt.Errorf("index.yaml にトップページ (slug: top) が定義されていません")
+	}
+
+	a.indexMu.Lock()
+	a.pages = pageMap
+	a.toc = toc
+	a.indexMu.Unlock()
+	a.renders.reset()
+	a.rebuildLinks()
+	return nil
+}
+
 func (a *app) manualAbsPath(rel string) string {
 	return filepath.Join(a.manualRoot, filepath.FromSlash(rel))
 }
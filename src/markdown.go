@@ -0,0 +1,939 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// マニュアルの Markdown を CommonMark の仕様に沿って HTML に変換する。
+// ブロック構造 (見出し・段落・リスト・引用・コード) を行ごとに組み立ててから、
+// 各ブロックの中身をインライン (強調・コード・リンク・画像) として解析する。
+// 生の HTML は書けない (すべて文字として表示する)。
+
+type mdKind int
+
+const (
+	mdDocument mdKind = iota
+	mdBlockQuote
+	mdList
+	mdItem
+	mdParagraph
+	mdHeading
+	mdThematicBreak
+	mdCodeBlock
+	mdTable
+	mdCallout
+)
+
+type mdNode struct {
+	kind      mdKind
+	parent    *mdNode
+	children  []*mdNode
+	open      bool
+	startLine int
+
+	// 段落・見出し・コードの中身
+	lines   []string
+	content string
+	level   int
+
+	// コードブロック
+	fenced      bool
+	fenceChar   byte
+	fenceLength int
+	fenceIndent int
+	info        string
+
+	// リストとリストの項目
+	list          mdListData
+	tight         bool
+	lastLineBlank bool
+
+	// 表
+	table *mdTableData
+
+	// 注意書きの囲み。::: で始めた囲みは fenceLength に : の数を持つ
+	callout *mdCalloutData
+
+	// チェックリストの項目の段落
+	task int
+}
+
+const (
+	taskUnchecked = 1
+	taskChecked   = 2
+)
+
+type mdListData struct {
+	ordered      bool
+	bullet       byte // '-' '+' '*' または番号の後の '.' ')'
+	start        int
+	markerOffset int
+	padding      int
+}
+
+type mdLinkRef struct {
+	dest  string
+	title string
+}
+
+type mdParser struct {
+	doc    *mdNode
+	tip    *mdNode
+	oldTip *mdNode
+	refs   map[string]mdLinkRef
+
+	line       string
+	lineNumber int
+	offset     int
+	nextNonsp  int
+	indent     int
+	blank      bool
+
+	allClosed            bool
+	lastMatchedContainer *mdNode
+}
+
+const mdCodeIndent = 4
+
+var (
+	reATXHeading      = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	reClosingATX      = regexp.MustCompile(`(?:^|[ \t]+)#+[ \t]*$`)
+	reCodeFence       = regexp.MustCompile("^`{3,}[^`]*$|^~{3,}")
+	reClosingFence    = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	reSetextHeading   = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	reThematicBreak   = regexp.MustCompile(`^(?:\*[ \t]*){3,}$|^(?:_[ \t]*){3,}$|^(?:-[ \t]*){3,}$`)
+	reBulletMarker    = regexp.MustCompile(`^[*+-]`)
+	reOrderedMarker   = regexp.MustCompile(`^(\d{1,9})([.)])`)
+	reLinkLabelLoose  = regexp.MustCompile(`\s+`)
+	reLeadingTabSpace = regexp.MustCompile(`^[ \t]*`)
+	reTaskMarker      = regexp.MustCompile(`^\[([ xX])\][ \t]+`)
+)
+
+// mdContext は描画するページの外の情報。[[slug]] のリンク先の解決とマクロ ({{include}} など) に使う。
+type mdContext struct {
+	// slug は描画するページ。自分自身の埋め込みを見つけるのに使う。
+	slug string
+	// pageLink は slug のページへのリンク先とタイトルを返す。目次にないページなら ok は false。
+	pageLink func(slug string) (href, title string, ok bool)
+	// readPage は {{include}} で埋め込むページの Markdown を返す。
+	readPage func(slug string) (string, error)
+
+	// マクロの値。now は {{today}} {{weekday}} {{shift}} の基準の時刻
+	now      time.Time
+	shifts   shiftClock
+	title    string
+	updated  time.Time
+	children []tocEntry
+}
+
+// markdownToHTML は Markdown を HTML に変換する。ctx が nil なら [[slug]] はすべて
+// 見つからないページとして表示する。
+func markdownToHTML(md string, ctx *mdContext) template.HTML {
+	return renderMarkdown(md, ctx).HTML
+}
+
+// outlineEntry はページ内の見出し。id はページ内リンク (#id) の行き先になる。
+type outlineEntry struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// renderedMarkdown は変換した HTML と、本文から集めた見出しとチェックリストの項目 (出現順)。
+// Includes は {{include}} で埋め込んだページ (埋め込んだページの中で埋め込んだものも含む)。
+// Volatile は {{today}} など時刻で値が変わるマクロを使ったかどうか。
+type renderedMarkdown struct {
+	HTML     template.HTML
+	Headings []outlineEntry
+	Tasks    []taskItem
+	Includes []string
+	Volatile bool
+}
+
+func renderMarkdown(md string, ctx *mdContext) renderedMarkdown {
+	doc, refs := parseMarkdown(md)
+	r := &mdRenderer{refs: refs, ctx: ctx, ids: make(map[string]bool), taskKeys: make(map[string]int)}
+	r.renderBlock(doc, false)
+	slices.Sort(r.includes)
+	return renderedMarkdown{HTML: template.HTML(r.b.String()), Headings: r.headings, Tasks: r.tasks, Includes: slices.Compact(r.includes), Volatile: r.volatile}
+}
+
+// parseMarkdown はブロック構造の木と、リンク参照定義を返す。
+func parseMarkdown(md string) (*mdNode, map[string]mdLinkRef) {
+	p := &mdParser{refs: make(map[string]mdLinkRef)}
+	p.doc = &mdNode{kind: mdDocument, open: true}
+	p.tip = p.doc
+
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+	md = strings.ReplaceAll(md, "\r", "\n")
+	md = strings.ReplaceAll(md, "\x00", "�")
+	lines := strings.Split(md, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		p.addLine(expandTabs(line))
+	}
+	for p.tip != nil {
+		p.finalize(p.tip)
+	}
+	p.processInlines(p.doc)
+	return p.doc, p.refs
+}
+
+// expandTabs は行頭のタブを4桁ごとの空白に展開する。インデントの判定を桁で行うため。
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\t' {
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		if c != ' ' {
+			b.WriteString(line[i:])
+			break
+		}
+		b.WriteByte(c)
+		col++
+	}
+	return b.String()
+}
+
+func (p *mdParser) findNextNonspace() {
+	i := p.offset
+	for i < len(p.line) && p.line[i] == ' ' {
+		i++
+	}
+	p.nextNonsp = i
+	p.indent = i - p.offset
+	p.blank = i >= len(p.line)
+}
+
+func (p *mdParser) advanceOffset(n int) {
+	p.offset = min(p.offset+n, len(p.line))
+}
+
+func (p *mdParser) advanceNextNonspace() {
+	p.offset = p.nextNonsp
+}
+
+func (p *mdParser) rest() string {
+	return p.line[p.offset:]
+}
+
+func (n *mdNode) lastChild() *mdNode {
+	if len(n.children) == 0 {
+		return nil
+	}
+	return n.children[len(n.children)-1]
+}
+
+// acceptsLines はそのブロックが行をそのまま受け取るかどうか。
+func (n *mdNode) acceptsLines() bool {
+	return n.kind == mdParagraph || n.kind == mdCodeBlock || n.kind == mdTable
+}
+
+func canContain(parent *mdNode, child mdKind) bool {
+	switch parent.kind {
+	case mdDocument, mdBlockQuote, mdItem, mdCallout:
+		return child != mdItem
+	case mdList:
+		return child == mdItem
+	}
+	return false
+}
+
+// continues は開いているブロックが現在の行でも続くかを調べる。
+// 0: 続く, 1: 続かない, 2: 行を使い切った (コードの閉じフェンス)。
+func (p *mdParser) continues(n *mdNode) int {
+	switch n.kind {
+	case mdBlockQuote:
+		if !p.blank && p.indent < mdCodeIndent && p.line[p.nextNonsp] == '>' {
+			p.advanceNextNonspace()
+			p.advanceOffset(1)
+			if p.offset < len(p.line) && p.line[p.offset] == ' ' {
+				p.advanceOffset(1)
+			}
+			return 0
+		}
+		return 1
+	case mdList:
+		return 0
+	case mdItem:
+		// 記号の後の中身の位置まで字下げした行は項目の続き (入れ子のリストや続きの段落)
+		if p.blank {
+			if len(n.children) == 0 {
+				return 1
+			}
+			p.advanceNextNonspace()
+			return 0
+		}
+		if p.indent >= n.list.markerOffset+n.list.padding {
+			p.advanceOffset(n.list.markerOffset + n.list.padding)
+			return 0
+		}
+		return 1
+	case mdCodeBlock:
+		if n.fenced {
+			rest := p.line[p.nextNonsp:]
+			if p.indent < mdCodeIndent && len(rest) >= n.fenceLength && rest[0] == n.fenceChar {
+				if m := reClosingFence.FindString(rest); m != "" && strings.Count(strings.TrimRight(m, " \t"), string(n.fenceChar)) >= n.fenceLength {
+					p.finalize(n)
+					return 2
+				}
+			}
+			// フェンスの字下げ分だけ行頭の空白を除く
+			for i := n.fenceIndent; i > 0 && p.offset < len(p.line) && p.line[p.offset] == ' '; i-- {
+				p.advanceOffset(1)
+			}
+			return 0
+		}
+		if p.indent >= mdCodeIndent {
+			p.advanceOffset(mdCodeIndent)
+			return 0
+		}
+		if p.blank {
+			p.advanceNextNonspace()
+			return 0
+		}
+		return 1
+	case mdCallout:
+		if !p.blank && p.indent < mdCodeIndent && closesCallout(n, p.line[p.nextNonsp:]) {
+			for p.tip != n {
+				p.finalize(p.tip)
+			}
+			p.finalize(n)
+			return 2
+		}
+		return 0
+	case mdParagraph:
+		if p.blank {
+			return 1
+		}
+		return 0
+	case mdTable:
+		if p.blank || p.indent < mdCodeIndent && interruptsTable(p.line[p.nextNonsp:]) {
+			return 1
+		}
+		return 0
+	}
+	return 1
+}
+
+func (p *mdParser) addLine(line string) {
+	p.line = line
+	p.lineNumber++
+	p.offset = 0
+	p.oldTip = p.tip
+
+	container := p.doc
+	for {
+		last := container.lastChild()
+		if last == nil || !last.open {
+			break
+		}
+		container = last
+		p.findNextNonspace()
+		switch p.continues(container) {
+		case 0:
+			continue
+		case 1:
+			container = container.parent
+		case 2:
+			return
+		}
+		break
+	}
+
+	p.allClosed = container == p.oldTip
+	p.lastMatchedContainer = container
+
+	matchedLeaf := container.kind != mdParagraph && container.acceptsLines()
+	for !matchedLeaf {
+		p.findNextNonspace()
+		if p.indent < mdCodeIndent && !reMaybeSpecial(p.line[p.nextNonsp:]) {
+			p.advanceNextNonspace()
+			break
+		}
+		started := p.blockStart(container)
+		if started == nil {
+			p.advanceNextNonspace()
+			break
+		}
+		container = started
+		if container.acceptsLines() || container.kind == mdHeading || container.kind == mdThematicBreak {
+			break
+		}
+	}
+
+	if !p.allClosed && !p.blank && p.tip.kind == mdParagraph {
+		// 段落の怠惰な続き
+		p.tip.lines = append(p.tip.lines, p.rest())
+		return
+	}
+
+	p.closeUnmatched()
+	if p.blank && container.lastChild() != nil {
+		container.lastChild().lastLineBlank = true
+	}
+	// 空の項目を始めた行の空白は、項目の後の空行として数えない
+	emptyItem := container.kind == mdItem && len(container.children) == 0 && container.startLine == p.lineNumber
+	lastLineBlank := p.blank && !(container.kind == mdBlockQuote || container.kind == mdCallout || (container.kind == mdCodeBlock && container.fenced) || emptyItem)
+	for t := container; t != nil; t = t.parent {
+		t.lastLineBlank = lastLineBlank
+	}
+
+	switch {
+	case container.acceptsLines():
+		container.lines = append(container.lines, p.rest())
+	case container.kind == mdHeading || container.kind == mdThematicBreak:
+	case !p.blank:
+		para := p.addChild(mdParagraph)
+		para.lines = append(para.lines, p.rest())
+	}
+}
+
+// reMaybeSpecial はブロックの始まりになりうる文字で始まるかを調べる。
+func reMaybeSpecial(s string) bool {
+	if s == "" {
+		return false
+	}
+	switch s[0] {
+	case '#', '`', '~', '*', '+', '-', '_', '=', '>', '|', ':', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return strings.HasPrefix(s, "｜")
+}
+
+// blockStart は新しいブロックの始まりを調べ、始まったブロックを返す。
+func (p *mdParser) blockStart(container *mdNode) *mdNode {
+	if p.indent >= mdCodeIndent {
+		if p.tip.kind != mdParagraph && !p.blank {
+			p.advanceOffset(mdCodeIndent)
+			p.closeUnmatched()
+			return p.addChild(mdCodeBlock)
+		}
+		return nil
+	}
+
+	rest := p.line[p.nextNonsp:]
+	switch {
+	case rest[0] == '>':
+		p.advanceNextNonspace()
+		p.advanceOffset(1)
+		if p.offset < len(p.line) && p.line[p.offset] == ' ' {
+			p.advanceOffset(1)
+		}
+		p.closeUnmatched()
+		return p.addChild(mdBlockQuote)
+
+	case reATXHeading.MatchString(rest):
+		p.advanceNextNonspace()
+		m := reATXHeading.FindString(rest)
+		p.advanceOffset(len(m))
+		p.closeUnmatched()
+		heading := p.addChild(mdHeading)
+		heading.level = strings.Count(strings.TrimRight(m, " \t"), "#")
+		text := reClosingATX.ReplaceAllString(p.rest(), "")
+		heading.content = strings.TrimSpace(text)
+		p.advanceOffset(len(p.rest()))
+		return heading
+
+	case reCodeFence.MatchString(rest):
+		fence := rest[0]
+		length := 0
+		for length < len(rest) && rest[length] == fence {
+			length++
+		}
+		p.closeUnmatched()
+		code := p.addChild(mdCodeBlock)
+		code.fenced = true
+		code.fenceChar = fence
+		code.fenceLength = length
+		code.fenceIndent = p.indent
+		code.info = unescapeMarkdown(strings.TrimSpace(rest[length:]))
+		p.advanceOffset(len(p.rest()))
+		return code
+
+	case reCalloutFence.MatchString(rest):
+		m := reCalloutFence.FindStringSubmatch(rest)
+		p.closeUnmatched()
+		callout := p.addChild(mdCallout)
+		callout.fenceLength = len(m[1])
+		callout.callout = newCallout(m[2], m[3])
+		p.advanceOffset(len(p.rest()))
+		return callout
+
+	case container.kind == mdParagraph && isTableDelimiterRow(rest) && p.tableHeaderMatches(container, rest):
+		p.closeUnmatched()
+		header := container.lines[len(container.lines)-1]
+		container.lines = container.lines[:len(container.lines)-1]
+		table := &mdNode{kind: mdTable, parent: container.parent, open: true, startLine: p.lineNumber - 1}
+		table.lines = []string{header}
+		parent := container.parent
+		if len(container.lines) == 0 {
+			parent.children[len(parent.children)-1] = table
+		} else {
+			// 見出し行より前の行は段落として残す
+			p.finalize(container)
+			parent.children = append(parent.children, table)
+		}
+		p.tip = table
+		return table
+
+	case container.kind == mdParagraph && reSetextHeading.MatchString(rest) && p.hasContentAfterRefs(container):
+		p.closeUnmatched()
+		heading := &mdNode{kind: mdHeading, parent: container.parent, open: true, startLine: container.startLine}
+		heading.level = 2
+		if rest[0] == '=' {
+			heading.level = 1
+		}
+		heading.content = strings.TrimSpace(strings.Join(container.lines, "\n"))
+		siblings := container.parent.children
+		siblings[len(siblings)-1] = heading
+		p.tip = heading
+		p.advanceOffset(len(p.rest()))
+		return heading
+
+	case reThematicBreak.MatchString(rest):
+		p.closeUnmatched()
+		hr := p.addChild(mdThematicBreak)
+		p.advanceOffset(len(p.rest()))
+		return hr
+	}
+
+	if data, ok := p.parseListMarker(container); ok {
+		p.closeUnmatched()
+		if p.tip.kind != mdList || !listsMatch(container.list, data) || container.kind != mdList {
+			list := p.addChild(mdList)
+			list.list = data
+		}
+		item := p.addChild(mdItem)
+		item.list = data
+		return item
+	}
+	return nil
+}
+
+// parseListMarker はリストの記号を読み、項目の中身の位置まで進める。
+func (p *mdParser) parseListMarker(container *mdNode) (mdListData, bool) {
+	rest := p.line[p.nextNonsp:]
+	data := mdListData{markerOffset: p.indent}
+	var markerLen int
+	if m := reBulletMarker.FindString(rest); m != "" {
+		data.bullet = m[0]
+		markerLen = 1
+	} else if m := reOrderedMarker.FindStringSubmatch(rest); m != nil && (container.kind != mdParagraph || m[1] == "1") {
+		data.ordered = true
+		data.start, _ = strconv.Atoi(m[1])
+		data.bullet = m[2][0]
+		markerLen = len(m[0])
+	} else {
+		return data, false
+	}
+
+	// 記号の後は空白か行末でなければならない
+	after := rest[markerLen:]
+	if after != "" && after[0] != ' ' {
+		return data, false
+	}
+	// 段落を中断する項目は空であってはならない
+	if container.kind == mdParagraph && strings.TrimSpace(after) == "" {
+		return data, false
+	}
+
+	p.advanceNextNonspace()
+	p.advanceOffset(markerLen)
+	spaces := 0
+	for p.offset+spaces < len(p.line) && p.line[p.offset+spaces] == ' ' {
+		spaces++
+	}
+	blankItem := p.offset+spaces >= len(p.line)
+	if spaces >= 5 || spaces < 1 || blankItem {
+		data.padding = markerLen + 1
+		if spaces > 0 {
+			p.advanceOffset(1)
+		}
+	} else {
+		data.padding = markerLen + spaces
+		p.advanceOffset(spaces)
+	}
+	return data, true
+}
+
+func listsMatch(a, b mdListData) bool {
+	return a.ordered == b.ordered && a.bullet == b.bullet
+}
+
+func (p *mdParser) closeUnmatched() {
+	if p.allClosed {
+		return
+	}
+	for p.oldTip != p.lastMatchedContainer {
+		parent := p.oldTip.parent
+		p.finalize(p.oldTip)
+		p.oldTip = parent
+	}
+	p.allClosed = true
+}
+
+func (p *mdParser) addChild(kind mdKind) *mdNode {
+	for !canContain(p.tip, kind) {
+		p.finalize(p.tip)
+	}
+	child := &mdNode{kind: kind, parent: p.tip, open: true, startLine: p.lineNumber}
+	p.tip.children = append(p.tip.children, child)
+	p.tip = child
+	return child
+}
+
+func (p *mdParser) finalize(n *mdNode) {
+	if !n.open {
+		p.tip = n.parent
+		return
+	}
+	n.open = false
+	switch n.kind {
+	case mdParagraph:
+		p.extractRefs(n)
+		if len(n.lines) == 0 {
+			n.parent.removeChild(n)
+		}
+	case mdCodeBlock:
+		if n.fenced {
+			// 1行目はフェンスの行
+			if len(n.lines) > 1 {
+				n.content = strings.Join(n.lines[1:], "\n") + "\n"
+			}
+		} else {
+			lines := n.lines
+			for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+				lines = lines[:len(lines)-1]
+			}
+			if len(lines) > 0 {
+				n.content = strings.Join(lines, "\n") + "\n"
+			}
+		}
+	case mdTable:
+		n.table = parseTable(n.lines)
+	case mdList:
+		n.tight = true
+		for i, item := range n.children {
+			if endsWithBlankLine(item) && i < len(n.children)-1 {
+				n.tight = false
+				break
+			}
+			for j, sub := range item.children {
+				if endsWithBlankLine(sub) && (i < len(n.children)-1 || j < len(item.children)-1) {
+					n.tight = false
+					break
+				}
+			}
+		}
+	}
+	p.tip = n.parent
+}
+
+func endsWithBlankLine(n *mdNode) bool {
+	for n != nil {
+		if n.lastLineBlank {
+			return true
+		}
+		if n.kind != mdList && n.kind != mdItem {
+			return false
+		}
+		n = n.lastChild()
+	}
+	return false
+}
+
+func (n *mdNode) removeChild(child *mdNode) {
+	for i, c := range n.children {
+		if c == child {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// hasContentAfterRefs は段落の先頭のリンク参照定義を取り出した後に、中身が残るかを返す。
+// 定義だけの段落は setext 見出しにならない。
+func (p *mdParser) hasContentAfterRefs(para *mdNode) bool {
+	p.extractRefs(para)
+	return len(para.lines) > 0
+}
+
+// extractRefs は段落の先頭にあるリンク参照定義 [label]: dest "title" を取り出す。
+func (p *mdParser) extractRefs(para *mdNode) {
+	text := strings.Join(para.lines, "\n")
+	for strings.HasPrefix(text, "[") {
+		n := p.parseReference(text)
+		if n == 0 {
+			break
+		}
+		text = text[n:]
+	}
+	if strings.TrimSpace(text) == "" {
+		para.lines = nil
+		return
+	}
+	para.lines = strings.Split(text, "\n")
+}
+
+func (p *mdParser) parseReference(s string) int {
+	sc := &mdScanner{s: s}
+	label, ok := sc.linkLabel()
+	if !ok || sc.peek() != ':' {
+		return 0
+	}
+	sc.pos++
+	sc.spacesAndNewline()
+	dest, ok := sc.linkDestination()
+	if !ok {
+		return 0
+	}
+	beforeTitle := sc.pos
+	sc.spacesAndNewline()
+	title, hasTitle := "", false
+	if sc.pos != beforeTitle {
+		title, hasTitle = sc.linkTitle()
+	}
+	if !hasTitle {
+		sc.pos = beforeTitle
+	}
+	end, ok := sc.lineEnd()
+	if !ok && hasTitle {
+		// タイトルの後に文字が続くなら、タイトルのない定義として読み直す
+		title = ""
+		sc.pos = beforeTitle
+		end, ok = sc.lineEnd()
+	}
+	if !ok {
+		return 0
+	}
+	key := normalizeLabel(label)
+	if key == "" {
+		return 0
+	}
+	if _, exists := p.refs[key]; !exists {
+		p.refs[key] = mdLinkRef{dest: dest, title: title}
+	}
+	return end
+}
+
+func normalizeLabel(label string) string {
+	label = strings.TrimSpace(label[1 : len(label)-1])
+	label = reLinkLabelLoose.ReplaceAllString(label, " ")
+	return strings.ToUpper(strings.ToLower(label))
+}
+
+// processInlines は段落と見出しの中身をインラインとして解析する。
+func (p *mdParser) processInlines(n *mdNode) {
+	switch n.kind {
+	case mdBlockQuote:
+		alertToCallout(n)
+	case mdParagraph:
+		n.content = strings.TrimRight(strings.Join(trimLeading(n.lines), "\n"), " \t")
+		// リストの項目の先頭の [ ] / [x] はチェックリストの項目
+		if n.parent.kind == mdItem && n.parent.children[0] == n {
+			if m := reTaskMarker.FindStringSubmatch(n.content); m != nil {
+				n.task = taskUnchecked
+				if m[1] != " " {
+					n.task = taskChecked
+				}
+				n.content = n.content[len(m[0]):]
+			}
+		}
+	}
+	for _, child := range n.children {
+		p.processInlines(child)
+	}
+}
+
+func trimLeading(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = reLeadingTabSpace.ReplaceAllString(line, "")
+	}
+	return out
+}
+
+type mdRenderer struct {
+	b    strings.Builder
+	refs map[string]mdLinkRef
+	ctx  *mdContext
+
+	ids      map[string]bool
+	headings []outlineEntry
+	taskKeys map[string]int
+	tasks    []taskItem
+
+	// including は埋め込んでいる途中のページ、includes は埋め込んだページ
+	including []string
+	includes  []string
+	volatile  bool
+}
+
+func (r *mdRenderer) cr() {
+	s := r.b.String()
+	if len(s) > 0 && s[len(s)-1] != '\n' {
+		r.b.WriteByte('\n')
+	}
+}
+
+func (r *mdRenderer) renderBlock(n *mdNode, tight bool) {
+	switch n.kind {
+	case mdDocument:
+		for _, child := range n.children {
+			r.renderBlock(child, false)
+		}
+	case mdParagraph:
+		if r.renderBlockMacro(n) {
+			return
+		}
+		if tight {
+			r.renderTaskBox(n)
+			r.renderInline(n.content)
+			return
+		}
+		r.cr()
+		r.b.WriteString("<p>")
+		r.renderTaskBox(n)
+		r.renderInline(n.content)
+		r.b.WriteString("</p>\n")
+	case mdHeading:
+		tag := "h" + strconv.Itoa(n.level)
+		inner := &mdRenderer{refs: r.refs, ctx: r.ctx}
+		inner.renderInline(n.content)
+		r.volatile = r.volatile || inner.volatile
+		text := html.UnescapeString(stripTags(inner.b.String()))
+		id := r.headingID(text)
+		r.headings = append(r.headings, outlineEntry{Level: n.level, ID: id, Text: text})
+		r.cr()
+		r.b.WriteString("<" + tag + ` id="` + escapeHTML(id) + `">`)
+		r.b.WriteString(inner.b.String())
+		r.b.WriteString("</" + tag + ">\n")
+	case mdThematicBreak:
+		r.cr()
+		r.b.WriteString("<hr />\n")
+	case mdCodeBlock:
+		r.cr()
+		r.b.WriteString("<pre><code")
+		if lang, _, _ := strings.Cut(n.info, " "); lang != "" {
+			r.b.WriteString(` class="language-` + escapeHTML(lang) + `"`)
+		}
+		r.b.WriteString(">")
+		r.b.WriteString(escapeHTML(n.content))
+		r.b.WriteString("</code></pre>\n")
+	case mdTable:
+		r.cr()
+		r.renderTable(n.table)
+	case mdBlockQuote:
+		r.cr()
+		r.b.WriteString("<blockquote>\n")
+		for _, child := range n.children {
+			r.renderBlock(child, false)
+		}
+		r.cr()
+		r.b.WriteString("</blockquote>\n")
+	case mdCallout:
+		r.cr()
+		r.renderCallout(n)
+	case mdList:
+		tag := "ul"
+		r.cr()
+		if n.list.ordered {
+			tag = "ol"
+			if n.list.start != 1 {
+				r.b.WriteString(`<ol start="` + strconv.Itoa(n.list.start) + `">` + "\n")
+			} else {
+				r.b.WriteString("<ol>\n")
+			}
+		} else {
+			r.b.WriteString("<ul>\n")
+		}
+		for _, item := range n.children {
+			r.renderBlock(item, n.tight)
+		}
+		r.cr()
+		r.b.WriteString("</" + tag + ">\n")
+	case mdItem:
+		if len(n.children) > 0 && n.children[0].task != 0 {
+			r.b.WriteString(`<li class="task-list-item">`)
+		} else {
+			r.b.WriteString("<li>")
+		}
+		for _, child := range n.children {
+			r.renderBlock(child, tight)
+		}
+		r.b.WriteString("</li>\n")
+	}
+}
+
+// headingID は見出しの id を返す。同じ id がすでにあれば -1、-2 を付ける。
+func (r *mdRenderer) headingID(text string) string {
+	base := headingSlug(text)
+	id := base
+	for i := 1; r.ids[id]; i++ {
+		id = base + "-" + strconv.Itoa(i)
+	}
+	if r.ids != nil {
+		r.ids[id] = true
+	}
+	return id
+}
+
+// headingSlug は見出しの文字から id を作る。英字は小文字にし、空白は - にして、
+// 文字 (日本語を含む)・数字・-・_ 以外は除く。
+func headingSlug(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case unicode.IsLetter(c), unicode.IsDigit(c), unicode.IsMark(c), c == '-', c == '_':
+			b.WriteRune(c)
+		case unicode.IsSpace(c):
+			b.WriteByte('-')
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func escapeHTML(s string) string {
+	return htmlEscaper.Replace(s)
+}
+
+// stripTags は HTML からタグを取り除いた文字列を返す。
+func stripTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
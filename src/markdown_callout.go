@@ -0,0 +1,104 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 注意書きの囲み (callout)。次の2つの書き方ができる。
+//
+//	:::warning 停電時の注意        > [!WARNING]
+//	本文                           > 本文
+//	:::
+//
+// ::: は閉じる行までを囲みの中身とし、中に別の囲みを入れるときは外側の : を増やす。
+// > [!種類] は GitHub と同じ書き方で、引用の1行目に書くと引用全体が囲みになる。
+
+type mdCalloutData struct {
+	kind  string
+	title string // 種類の後に書いた見出し。空なら種類の名前を使う
+}
+
+type calloutStyle struct {
+	title string
+	icon  string
+}
+
+var calloutStyles = map[string]calloutStyle{
+	"note":      {title: "メモ", icon: "ℹ"},
+	"tip":       {title: "ヒント", icon: "💡"},
+	"important": {title: "重要", icon: "❗"},
+	"caution":   {title: "注意", icon: "⚠"},
+	"warning":   {title: "警告", icon: "⛔"},
+}
+
+// calloutAliases は書ける種類の名前。一覧にない種類は note として表示する。
+var calloutAliases = map[string]string{
+	"note":      "note",
+	"info":      "note",
+	"メモ":        "note",
+	"tip":       "tip",
+	"hint":      "tip",
+	"ヒント":       "tip",
+	"important": "important",
+	"重要":        "important",
+	"caution":   "caution",
+	"注意":        "caution",
+	"warning":   "warning",
+	"danger":    "warning",
+	"警告":        "warning",
+}
+
+var (
+	reCalloutFence = regexp.MustCompile(`^(:{3,})[ \t]*([^\s:][^\s]*)[ \t]*(.*)$`)
+	reCalloutClose = regexp.MustCompile(`^:{3,}[ \t]*$`)
+	reAlertMarker  = regexp.MustCompile(`^\[!([^\]\s]+)\][ \t]*(.*)$`)
+)
+
+func newCallout(kind, title string) *mdCalloutData {
+	name, ok := calloutAliases[strings.ToLower(kind)]
+	if !ok {
+		name = "note"
+	}
+	return &mdCalloutData{kind: name, title: strings.TrimSpace(title)}
+}
+
+// closesCallout は ::: の囲みを閉じる行かどうかを返す。
+func closesCallout(n *mdNode, rest string) bool {
+	return reCalloutClose.MatchString(rest) && strings.Count(strings.TrimRight(rest, " \t"), ":") >= n.fenceLength
+}
+
+// alertToCallout は1行目が [!種類] の引用を囲みに変える。
+func alertToCallout(n *mdNode) {
+	if n.kind != mdBlockQuote || len(n.children) == 0 || n.children[0].kind != mdParagraph {
+		return
+	}
+	para := n.children[0]
+	m := reAlertMarker.FindStringSubmatch(strings.TrimLeft(para.lines[0], " \t"))
+	if m == nil {
+		return
+	}
+	n.kind = mdCallout
+	n.callout = newCallout(m[1], m[2])
+	para.lines = para.lines[1:]
+	if len(para.lines) == 0 {
+		n.removeChild(para)
+	}
+}
+
+func (r *mdRenderer) renderCallout(n *mdNode) {
+	style := calloutStyles[n.callout.kind]
+	r.b.WriteString(`<div class="callout callout--` + n.callout.kind + `" role="note">` + "\n")
+	r.b.WriteString(`<p class="callout__title"><span class="callout__icon" aria-hidden="true">` + style.icon + `</span>`)
+	if n.callout.title != "" {
+		r.renderInline(n.callout.title)
+	} else {
+		r.b.WriteString(style.title)
+	}
+	r.b.WriteString("</p>\n")
+	for _, child := range n.children {
+		r.renderBlock(child, false)
+	}
+	r.cr()
+	r.b.WriteString("</div>\n")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"slices"
+)
+
+// {{include slug}} / {{include slug#見出し}} だけの段落は、別のページの本文 (見出しを指定したら
+// その見出しの下の節) に置き換える (マクロの1つとして markdown_macro.go で登録している)。
+// 描画するたびに読み込むので、埋め込んだページを直せば埋め込んでいるすべてのページに反映される。
+
+// maxIncludeDepth は埋め込んだページの中でさらに埋め込める深さ。
+const maxIncludeDepth = 5
+
+func (r *mdRenderer) include(slug, section string) error {
+	switch {
+	case slug == "":
+		return fmt.Errorf("{{include}} に埋め込むページの slug がありません")
+	case r.ctx == nil || r.ctx.readPage == nil:
+		return fmt.Errorf("ページ「%s」はここでは埋め込めません", slug)
+	case slug == r.ctx.slug || slices.Contains(r.including, slug):
+		return fmt.Errorf("ページ「%s」の埋め込みが循環しています", slug)
+	case len(r.including) >= maxIncludeDepth:
+		return fmt.Errorf("埋め込みが深すぎるため、ページ「%s」を埋め込めません (%d 段まで)", slug, maxIncludeDepth)
+	}
+
+	md, err := r.ctx.readPage(slug)
+	if err != nil {
+		return err
+	}
+	r.includes = append(r.includes, slug)
+
+	doc, refs := parseMarkdown(md)
+	blocks := doc.children
+	if section != "" {
+		if blocks = r.sectionBlocks(doc, refs, section); blocks == nil {
+			return fmt.Errorf("ページ「%s」に見出し「%s」がありません", slug, section)
+		}
+	}
+
+	saved := r.refs
+	r.refs = refs
+	r.including = append(r.including, slug)
+	for _, block := range blocks {
+		r.renderBlock(block, false)
+	}
+	r.including = r.including[:len(r.including)-1]
+	r.refs = saved
+	return nil
+}
+
+// sectionBlocks は見出し (文字か id) の下から、同じかより上の階層の次の見出しまでのブロックを返す。
+func (r *mdRenderer) sectionBlocks(doc *mdNode, refs map[string]mdLinkRef, section string) []*mdNode {
+	want := headingSlug(section)
+	for i, n := range doc.children {
+		if n.kind != mdHeading {
+			continue
+		}
+		inner := &mdRenderer{refs: refs, ctx: r.ctx}
+		inner.renderInline(n.content)
+		if headingSlug(html.UnescapeString(stripTags(inner.b.String()))) != want {
+			continue
+		}
+		end := len(doc.children)
+		for j := i + 1; j < len(doc.children); j++ {
+			if next := doc.children[j]; next.kind == mdHeading && next.level <= n.level {
+				end = j
+				break
+			}
+		}
+		return doc.children[i+1 : end : end]
+	}
+	return nil
+}
@@ -0,0 +1,862 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 段落と見出しの中身 (インライン) の解析。強調は CommonMark の区切り文字の
+// アルゴリズムで、リンクは [ の位置を積んだスタックで対応を取る。
+
+type mdInlineKind int
+
+const (
+	inText mdInlineKind = iota
+	inSoftBreak
+	inLineBreak
+	inCode
+	inEmph
+	inStrong
+	inLink
+	inImage
+	inWikiLink
+	inMacro
+	inRoot
+)
+
+type mdInline struct {
+	kind    mdInlineKind
+	literal string
+	dest    string
+	title   string
+
+	parent, first, last, prev, next *mdInline
+}
+
+func (n *mdInline) appendChild(child *mdInline) {
+	child.unlink()
+	child.parent = n
+	if n.last != nil {
+		n.last.next = child
+		child.prev = n.last
+	} else {
+		n.first = child
+	}
+	n.last = child
+}
+
+func (n *mdInline) insertAfter(sibling *mdInline) {
+	sibling.unlink()
+	sibling.next = n.next
+	if sibling.next != nil {
+		sibling.next.prev = sibling
+	}
+	sibling.prev = n
+	n.next = sibling
+	sibling.parent = n.parent
+	if sibling.next == nil && sibling.parent != nil {
+		sibling.parent.last = sibling
+	}
+}
+
+func (n *mdInline) unlink() {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else if n.parent != nil {
+		n.parent.first = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else if n.parent != nil {
+		n.parent.last = n.prev
+	}
+	n.parent, n.prev, n.next = nil, nil, nil
+}
+
+// mdDelimiter は強調の候補になる * と _ の並び。
+type mdDelimiter struct {
+	char       byte
+	count      int
+	origCount  int
+	node       *mdInline
+	prev, next *mdDelimiter
+	canOpen    bool
+	canClose   bool
+}
+
+// mdBracket はリンクや画像の始まりの [ と ![。
+type mdBracket struct {
+	node         *mdInline
+	prev         *mdBracket
+	prevDelim    *mdDelimiter
+	index        int
+	image        bool
+	active       bool
+	bracketAfter bool
+}
+
+type mdInlineParser struct {
+	mdScanner
+	refs       map[string]mdLinkRef
+	delimiters *mdDelimiter
+	brackets   *mdBracket
+}
+
+var (
+	reEntity        = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	reAutolinkURL   = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*>`)
+	reWikiLink      = regexp.MustCompile(`^\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+	reAutolinkEmail = regexp.MustCompile("^<([a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>")
+)
+
+// renderInline はインラインの Markdown を HTML にして書き出す。
+func (r *mdRenderer) renderInline(s string) {
+	r.writeInlines(parseInline(s, r.refs))
+}
+
+func parseInline(s string, refs map[string]mdLinkRef) *mdInline {
+	p := &mdInlineParser{mdScanner: mdScanner{s: s}, refs: refs}
+	root := &mdInline{kind: inRoot}
+	for p.pos < len(p.s) {
+		p.parseOne(root)
+	}
+	p.processEmphasis(nil)
+	return root
+}
+
+func text(s string) *mdInline {
+	return &mdInline{kind: inText, literal: s}
+}
+
+func (p *mdInlineParser) parseOne(block *mdInline) {
+	c := p.s[p.pos]
+	switch c {
+	case '\n':
+		p.parseNewline(block)
+	case '\\':
+		p.parseBackslash(block)
+	case '`':
+		p.parseBackticks(block)
+	case '*', '_':
+		p.handleDelim(c, block)
+	case '[':
+		if p.parseWikiLink(block) {
+			return
+		}
+		p.pos++
+		node := text("[")
+		block.appendChild(node)
+		p.addBracket(node, p.pos-1, false)
+	case '!':
+		p.pos++
+		if p.peek() == '[' {
+			p.pos++
+			node := text("![")
+			block.appendChild(node)
+			p.addBracket(node, p.pos-1, true)
+		} else {
+			block.appendChild(text("!"))
+		}
+	case ']':
+		p.parseCloseBracket(block)
+	case '<':
+		p.parseAutolink(block)
+	case '&':
+		p.parseEntity(block)
+	case '{':
+		if !p.parseMacro(block) {
+			p.pos++
+			block.appendChild(text("{"))
+		}
+	default:
+		p.parseString(block)
+	}
+}
+
+func isInlineSpecial(c byte) bool {
+	switch c {
+	case '\n', '\\', '`', '*', '_', '[', ']', '!', '<', '&', '{':
+		return true
+	}
+	return false
+}
+
+func (p *mdInlineParser) parseString(block *mdInline) {
+	start := p.pos
+	for p.pos < len(p.s) && !isInlineSpecial(p.s[p.pos]) {
+		p.pos++
+	}
+	block.appendChild(text(p.s[start:p.pos]))
+}
+
+// parseNewline は改行を、直前の空白が2つ以上なら強制改行、そうでなければ
+// ソフト改行 (表示上は空白) にする。
+func (p *mdInlineParser) parseNewline(block *mdInline) {
+	p.pos++
+	last := block.last
+	if last != nil && last.kind == inText && strings.HasSuffix(last.literal, " ") {
+		hard := strings.HasSuffix(last.literal, "  ")
+		last.literal = strings.TrimRight(last.literal, " ")
+		if hard {
+			block.appendChild(&mdInline{kind: inLineBreak})
+		} else {
+			block.appendChild(&mdInline{kind: inSoftBreak})
+		}
+	} else {
+		block.appendChild(&mdInline{kind: inSoftBreak})
+	}
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *mdInlineParser) parseBackslash(block *mdInline) {
+	p.pos++
+	switch {
+	case p.peek() == '\n':
+		p.pos++
+		block.appendChild(&mdInline{kind: inLineBreak})
+		for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+			p.pos++
+		}
+	case p.pos < len(p.s) && isASCIIPunct(p.s[p.pos]):
+		block.appendChild(text(p.s[p.pos : p.pos+1]))
+		p.pos++
+	default:
+		block.appendChild(text("\\"))
+	}
+}
+
+func (p *mdInlineParser) parseBackticks(block *mdInline) {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] == '`' {
+		p.pos++
+	}
+	ticks := p.s[start:p.pos]
+	afterOpen := p.pos
+	for i := afterOpen; i < len(p.s); {
+		if p.s[i] != '`' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(p.s) && p.s[j] == '`' {
+			j++
+		}
+		if j-i == len(ticks) {
+			content := strings.ReplaceAll(p.s[afterOpen:i], "\n", " ")
+			if len(content) > 2 && content[0] == ' ' && content[len(content)-1] == ' ' && strings.Trim(content, " ") != "" {
+				content = content[1 : len(content)-1]
+			}
+			block.appendChild(&mdInline{kind: inCode, literal: content})
+			p.pos = j
+			return
+		}
+		i = j
+	}
+	// 閉じる ` がなければそのまま文字として扱う
+	block.appendChild(text(ticks))
+}
+
+// parseWikiLink は [[slug]] と [[slug|表示する文字]] を読む。リンク先は描画するときに目次から探す。
+func (p *mdInlineParser) parseWikiLink(block *mdInline) bool {
+	m := reWikiLink.FindStringSubmatch(p.s[p.pos:])
+	if m == nil || strings.TrimSpace(m[1]) == "" {
+		return false
+	}
+	p.pos += len(m[0])
+	block.appendChild(&mdInline{kind: inWikiLink, dest: strings.TrimSpace(m[1]), literal: strings.TrimSpace(m[2])})
+	return true
+}
+
+func (p *mdInlineParser) parseAutolink(block *mdInline) {
+	rest := p.s[p.pos:]
+	if m := reAutolinkEmail.FindStringSubmatch(rest); m != nil {
+		p.pos += len(m[0])
+		link := &mdInline{kind: inLink, dest: "mailto:" + m[1]}
+		link.appendChild(text(m[1]))
+		block.appendChild(link)
+		return
+	}
+	if m := reAutolinkURL.FindString(rest); m != "" {
+		p.pos += len(m)
+		dest := m[1 : len(m)-1]
+		link := &mdInline{kind: inLink, dest: dest}
+		link.appendChild(text(dest))
+		block.appendChild(link)
+		return
+	}
+	// HTML のタグは書けないので、< は文字として表示する
+	p.pos++
+	block.appendChild(text("<"))
+}
+
+func (p *mdInlineParser) parseEntity(block *mdInline) {
+	if m := reEntity.FindString(p.s[p.pos:]); m != "" {
+		if decoded := html.UnescapeString(m); decoded != m {
+			p.pos += len(m)
+			block.appendChild(text(decoded))
+			return
+		}
+	}
+	p.pos++
+	block.appendChild(text("&"))
+}
+
+// scanDelims は * や _ の並びが強調を開けるか・閉じられるかを前後の文字から判断する。
+func (p *mdInlineParser) scanDelims(c byte) (count int, canOpen, canClose bool) {
+	start := p.pos
+	for p.pos+count < len(p.s) && p.s[p.pos+count] == c {
+		count++
+	}
+	before, after := '\n', '\n'
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.s[:start])
+	}
+	if start+count < len(p.s) {
+		after, _ = utf8.DecodeRuneInString(p.s[start+count:])
+	}
+	afterSpace, afterPunct := unicode.IsSpace(after), isPunctRune(after)
+	beforeSpace, beforePunct := unicode.IsSpace(before), isPunctRune(before)
+
+	leftFlanking := !afterSpace && (!afterPunct || beforeSpace || beforePunct)
+	rightFlanking := !beforeSpace && (!beforePunct || afterSpace || afterPunct)
+	if c == '_' {
+		canOpen = leftFlanking && (!rightFlanking || beforePunct)
+		canClose = rightFlanking && (!leftFlanking || afterPunct)
+	} else {
+		canOpen, canClose = leftFlanking, rightFlanking
+	}
+	return count, canOpen, canClose
+}
+
+func isPunctRune(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && (c >= '!' && c <= '/' || c >= ':' && c <= '@' || c >= '[' && c <= '`' || c >= '{' && c <= '~')
+}
+
+func (p *mdInlineParser) handleDelim(c byte, block *mdInline) {
+	count, canOpen, canClose := p.scanDelims(c)
+	node := text(p.s[p.pos : p.pos+count])
+	p.pos += count
+	block.appendChild(node)
+	if !canOpen && !canClose {
+		return
+	}
+	d := &mdDelimiter{char: c, count: count, origCount: count, node: node, prev: p.delimiters, canOpen: canOpen, canClose: canClose}
+	if d.prev != nil {
+		d.prev.next = d
+	}
+	p.delimiters = d
+}
+
+func (p *mdInlineParser) removeDelimiter(d *mdDelimiter) {
+	if d.prev != nil {
+		d.prev.next = d.next
+	}
+	if d.next != nil {
+		d.next.prev = d.prev
+	} else {
+		p.delimiters = d.prev
+	}
+}
+
+// processEmphasis は stackBottom より上の区切り文字を対応させて強調にする。
+func (p *mdInlineParser) processEmphasis(stackBottom *mdDelimiter) {
+	var openersBottom [12]*mdDelimiter
+	for i := range openersBottom {
+		openersBottom[i] = stackBottom
+	}
+
+	closer := p.delimiters
+	for closer != nil && closer.prev != stackBottom {
+		closer = closer.prev
+	}
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+		bottomIndex := closer.origCount % 3
+		if closer.canOpen {
+			bottomIndex += 3
+		}
+		if closer.char == '*' {
+			bottomIndex += 6
+		}
+
+		opener := closer.prev
+		found := false
+		for opener != nil && opener != stackBottom && opener != openersBottom[bottomIndex] {
+			oddMatch := (closer.canOpen || opener.canClose) && closer.origCount%3 != 0 && (opener.origCount+closer.origCount)%3 == 0
+			if opener.char == closer.char && opener.canOpen && !oddMatch {
+				found = true
+				break
+			}
+			opener = opener.prev
+		}
+
+		oldCloser := closer
+		if !found {
+			closer = closer.next
+		} else {
+			use := 1
+			if closer.count >= 2 && opener.count >= 2 {
+				use = 2
+			}
+			openerNode, closerNode := opener.node, closer.node
+			opener.count -= use
+			closer.count -= use
+			openerNode.literal = openerNode.literal[:len(openerNode.literal)-use]
+			closerNode.literal = closerNode.literal[:len(closerNode.literal)-use]
+
+			emph := &mdInline{kind: inEmph}
+			if use == 2 {
+				emph.kind = inStrong
+			}
+			for n := openerNode.next; n != nil && n != closerNode; {
+				next := n.next
+				emph.appendChild(n)
+				n = next
+			}
+			openerNode.insertAfter(emph)
+
+			// 間にある区切り文字は対応しないまま残る
+			opener.next = closer
+			closer.prev = opener
+
+			if opener.count == 0 {
+				openerNode.unlink()
+				p.removeDelimiter(opener)
+			}
+			if closer.count == 0 {
+				closerNode.unlink()
+				next := closer.next
+				p.removeDelimiter(closer)
+				closer = next
+			}
+		}
+		if !found {
+			openersBottom[bottomIndex] = oldCloser.prev
+			if !oldCloser.canOpen {
+				p.removeDelimiter(oldCloser)
+			}
+		}
+	}
+
+	for p.delimiters != nil && p.delimiters != stackBottom {
+		p.removeDelimiter(p.delimiters)
+	}
+}
+
+func (p *mdInlineParser) addBracket(node *mdInline, index int, image bool) {
+	if p.brackets != nil {
+		p.brackets.bracketAfter = true
+	}
+	p.brackets = &mdBracket{node: node, prev: p.brackets, prevDelim: p.delimiters, index: index, image: image, active: true}
+}
+
+// parseCloseBracket は ] を直前の [ と対応させ、インラインのリンクか
+// 参照リンクとして読めればリンク (画像) にする。
+func (p *mdInlineParser) parseCloseBracket(block *mdInline) {
+	start := p.pos
+	p.pos++
+	opener := p.brackets
+	if opener == nil {
+		block.appendChild(text("]"))
+		return
+	}
+	if !opener.active {
+		block.appendChild(text("]"))
+		p.brackets = opener.prev
+		return
+	}
+
+	var dest, title string
+	matched := false
+	afterBracket := p.pos
+	if p.peek() == '(' {
+		p.pos++
+		p.spacesAndNewline()
+		if d, ok := p.linkDestination(); ok {
+			beforeTitle := p.pos
+			p.spacesAndNewline()
+			t := ""
+			if p.pos != beforeTitle {
+				t, _ = p.linkTitle()
+			}
+			p.spacesAndNewline()
+			if p.peek() == ')' {
+				p.pos++
+				dest, title, matched = d, t, true
+			}
+		}
+		if !matched {
+			p.pos = afterBracket
+		}
+	}
+
+	if !matched {
+		// 参照リンク [text][label]、[label][]、[label]
+		var label string
+		beforeLabel := p.pos
+		if l, ok := p.linkLabel(); ok {
+			label = l
+		} else if strings.HasPrefix(p.s[p.pos:], "[]") {
+			p.pos += 2
+		} else {
+			p.pos = beforeLabel
+		}
+		if label == "" && !opener.bracketAfter {
+			label = p.s[opener.index : start+1]
+		}
+		if label != "" {
+			if ref, ok := p.refs[normalizeLabel(label)]; ok {
+				dest, title, matched = ref.dest, ref.title, true
+			}
+		}
+		if !matched {
+			p.pos = afterBracket
+		}
+	}
+
+	if !matched {
+		p.brackets = opener.prev
+		block.appendChild(text("]"))
+		return
+	}
+
+	link := &mdInline{kind: inLink, dest: dest, title: title}
+	if opener.image {
+		link.kind = inImage
+	}
+	for n := opener.node.next; n != nil; {
+		next := n.next
+		link.appendChild(n)
+		n = next
+	}
+	block.appendChild(link)
+	p.processEmphasis(opener.prevDelim)
+	p.brackets = opener.prev
+	opener.node.unlink()
+
+	// リンクの中にリンクは作れないので、手前の [ を無効にする
+	if !opener.image {
+		for b := p.brackets; b != nil; b = b.prev {
+			if !b.image {
+				b.active = false
+			}
+		}
+	}
+}
+
+// mdScanner はリンクのラベル・行き先・タイトルを読む。インラインの解析と
+// リンク参照定義の両方で使う。
+type mdScanner struct {
+	s   string
+	pos int
+}
+
+func (sc *mdScanner) peek() byte {
+	if sc.pos < len(sc.s) {
+		return sc.s[sc.pos]
+	}
+	return 0
+}
+
+// spacesAndNewline は空白と、高々1つの改行を読み飛ばす。
+func (sc *mdScanner) spacesAndNewline() {
+	newline := false
+	for sc.pos < len(sc.s) {
+		switch c := sc.s[sc.pos]; {
+		case c == ' ' || c == '\t':
+		case c == '\n' && !newline:
+			newline = true
+		default:
+			return
+		}
+		sc.pos++
+	}
+}
+
+// lineEnd は行末までの空白を読み、行末なら次の行の先頭の位置を返す。
+func (sc *mdScanner) lineEnd() (int, bool) {
+	i := sc.pos
+	for i < len(sc.s) && (sc.s[i] == ' ' || sc.s[i] == '\t') {
+		i++
+	}
+	if i == len(sc.s) {
+		return i, true
+	}
+	if sc.s[i] == '\n' {
+		return i + 1, true
+	}
+	return 0, false
+}
+
+// linkLabel は [ ] で囲まれたラベルを、括弧を含めて返す。
+func (sc *mdScanner) linkLabel() (string, bool) {
+	if sc.peek() != '[' {
+		return "", false
+	}
+	for i := sc.pos + 1; i < len(sc.s) && i-sc.pos <= 1000; i++ {
+		switch sc.s[i] {
+		case '\\':
+			i++
+		case '[':
+			return "", false
+		case ']':
+			label := sc.s[sc.pos : i+1]
+			if strings.TrimSpace(label[1:len(label)-1]) == "" {
+				return "", false
+			}
+			sc.pos = i + 1
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// linkDestination はリンクの行き先を読み、エスケープを解いて返す。
+func (sc *mdScanner) linkDestination() (string, bool) {
+	if sc.peek() == '<' {
+		for i := sc.pos + 1; i < len(sc.s); i++ {
+			switch sc.s[i] {
+			case '\\':
+				i++
+			case '\n', '<':
+				return "", false
+			case '>':
+				dest := unescapeMarkdown(sc.s[sc.pos+1 : i])
+				sc.pos = i + 1
+				return dest, true
+			}
+		}
+		return "", false
+	}
+
+	start, depth := sc.pos, 0
+	i := sc.pos
+loop:
+	for ; i < len(sc.s); i++ {
+		c := sc.s[i]
+		switch {
+		case c == '\\' && i+1 < len(sc.s) && isASCIIPunct(sc.s[i+1]):
+			i++
+		case c == '(':
+			depth++
+			if depth > 32 {
+				return "", false
+			}
+		case c == ')':
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		case c <= ' ' || c == 0x7f:
+			break loop
+		}
+	}
+	if depth != 0 || i == start && (i >= len(sc.s) || sc.s[i] != ')') {
+		return "", false
+	}
+	sc.pos = i
+	return unescapeMarkdown(sc.s[start:i]), true
+}
+
+// linkTitle は "..."、'...'、(...) のいずれかで囲まれたタイトルを読む。
+func (sc *mdScanner) linkTitle() (string, bool) {
+	open := sc.peek()
+	closeChar := open
+	switch open {
+	case '"', '\'':
+	case '(':
+		closeChar = ')'
+	default:
+		return "", false
+	}
+	for i := sc.pos + 1; i < len(sc.s); i++ {
+		switch c := sc.s[i]; {
+		case c == '\\':
+			i++
+		case c == closeChar:
+			title := unescapeMarkdown(sc.s[sc.pos+1 : i])
+			sc.pos = i + 1
+			return title, true
+		case open == '(' && c == '(':
+			return "", false
+		case c == '\n' && i+1 < len(sc.s) && sc.s[i+1] == '\n':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// unescapeMarkdown はバックスラッシュによるエスケープと文字参照を解く。
+func unescapeMarkdown(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			i++
+			b.WriteByte(s[i])
+		case c == '&':
+			if m := reEntity.FindString(s[i:]); m != "" {
+				b.WriteString(html.UnescapeString(m))
+				i += len(m) - 1
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (r *mdRenderer) writeInlines(parent *mdInline) {
+	b := &r.b
+	for n := parent.first; n != nil; n = n.next {
+		switch n.kind {
+		case inText:
+			b.WriteString(escapeHTML(n.literal))
+		case inSoftBreak:
+			b.WriteString("\n")
+		case inLineBreak:
+			b.WriteString("<br />\n")
+		case inCode:
+			b.WriteString("<code>" + escapeHTML(n.literal) + "</code>")
+		case inEmph:
+			b.WriteString("<em>")
+			r.writeInlines(n)
+			b.WriteString("</em>")
+		case inStrong:
+			b.WriteString("<strong>")
+			r.writeInlines(n)
+			b.WriteString("</strong>")
+		case inLink:
+			b.WriteString(`<a href="` + escapeHTML(safeURL(n.dest)) + `"`)
+			if n.title != "" {
+				b.WriteString(` title="` + escapeHTML(n.title) + `"`)
+			}
+			b.WriteString(">")
+			r.writeInlines(n)
+			b.WriteString("</a>")
+		case inImage:
+			var alt strings.Builder
+			writePlainText(&alt, n)
+			b.WriteString(`<img src="` + escapeHTML(safeURL(n.dest)) + `" alt="` + escapeHTML(alt.String()) + `"`)
+			if n.title != "" {
+				b.WriteString(` title="` + escapeHTML(n.title) + `"`)
+			}
+			b.WriteString(" />")
+		case inWikiLink:
+			r.writeWikiLink(n)
+		case inMacro:
+			r.writeMacro(n)
+		}
+	}
+}
+
+// writeWikiLink は [[slug]] を目次のページへのリンクにする。目次にないページは
+// 壊れたリンクとして、リンクにせずに表示する。
+func (r *mdRenderer) writeWikiLink(n *mdInline) {
+	slug, anchor, hasAnchor := strings.Cut(n.dest, "#")
+	var (
+		href, title string
+		ok          bool
+	)
+	switch {
+	case slug == "" && hasAnchor:
+		// [[#見出し]] は同じページの中の見出しへのリンク
+		href, title, ok = "", anchor, true
+	case r.ctx != nil && r.ctx.pageLink != nil:
+		href, title, ok = r.ctx.pageLink(slug)
+	}
+	if hasAnchor && ok {
+		href += "#" + headingSlug(anchor)
+		if slug != "" {
+			title += " » " + anchor
+		}
+	}
+	label := n.literal
+	if label == "" {
+		label = title
+	}
+	if label == "" {
+		label = n.dest
+	}
+	if !ok {
+		r.b.WriteString(`<span class="wikilink wikilink--broken" title="` + escapeHTML("ページ「"+slug+"」は目次にありません") + `">` + escapeHTML(label) + "</span>")
+		return
+	}
+	r.b.WriteString(`<a class="wikilink" href="` + escapeHTML(safeURL(href)) + `">` + escapeHTML(label) + "</a>")
+}
+
+// writePlainText は画像の代替テキスト用に、書式を除いた文字だけを書き出す。
+func writePlainText(b *strings.Builder, parent *mdInline) {
+	for n := parent.first; n != nil; n = n.next {
+		switch n.kind {
+		case inText, inCode, inMacro:
+			b.WriteString(n.literal)
+		case inSoftBreak, inLineBreak:
+			b.WriteString("\n")
+		case inWikiLink:
+			b.WriteString(cmp.Or(n.literal, n.dest))
+		default:
+			writePlainText(b, n)
+		}
+	}
+}
+
+// safeURL はリンク先をパーセントエンコードする。スクリプトを実行できる
+// javascript: などのスキームは空にする。
+func safeURL(dest string) string {
+	scheme := strings.ToLower(strings.TrimSpace(dest))
+	for _, unsafe := range []string{"javascript:", "vbscript:", "file:", "data:"} {
+		if strings.HasPrefix(scheme, unsafe) {
+			if unsafe == "data:" && isSafeDataImage(scheme) {
+				break
+			}
+			return ""
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(dest); i++ {
+		c := dest[i]
+		switch {
+		case c == '%' && i+2 < len(dest) && isHex(dest[i+1]) && isHex(dest[i+2]):
+			b.WriteByte(c)
+		case c < utf8.RuneSelf && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(";/?:@&=+$,-_.!~*'()#", c) >= 0):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isSafeDataImage(s string) bool {
+	for _, prefix := range []string{"data:image/png", "data:image/gif", "data:image/jpeg", "data:image/webp"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
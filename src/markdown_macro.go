@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// マクロ。本文に {{today}} のように書くと、表示するときに計算した値に置き換える。
+// 使えるのは macros に登録した名前だけで、本文を Go のテンプレートとして実行することはない。
+// 値はすべてエスケープして書き出し、使えないマクロはその場所に理由を表示する。
+
+type macro struct {
+	// volatile は値が時刻で変わるマクロ。描画結果のキャッシュを日付とシフトごとに分ける。
+	volatile bool
+	// value は文中で使えるマクロの値を返す。
+	value func(ctx *mdContext, arg string) (string, error)
+	// block は1行に単独で書いたときだけ使えるマクロで、ブロックを書き出す。
+	block func(r *mdRenderer, arg string) error
+}
+
+var macros map[string]macro
+
+func init() {
+	// include と children は描画の途中で renderBlock を呼ぶため、初期化の循環を避けて init で登録する
+	macros = map[string]macro{
+		"today":      {value: macroToday, volatile: true},
+		"weekday":    {value: macroWeekday, volatile: true},
+		"shift":      {value: macroShift, volatile: true},
+		"updated":    {value: macroUpdated},
+		"page.title": {value: macroPageTitle},
+		"children":   {block: (*mdRenderer).macroChildren},
+		"include":    {block: (*mdRenderer).macroInclude},
+	}
+}
+
+var (
+	reMacro      = regexp.MustCompile(`^\{\{[ \t]*([A-Za-z][A-Za-z0-9_.-]*)(?:[ \t]+([^{}\n]*?))?[ \t]*\}\}`)
+	reBlockMacro = regexp.MustCompile(`^\{\{[ \t]*([A-Za-z][A-Za-z0-9_.-]*)(?:[ \t]+([^{}\n]*?))?[ \t]*\}\}$`)
+)
+
+var japaneseWeekdays = [...]string{"日曜日", "月曜日", "火曜日", "水曜日", "木曜日", "金曜日", "土曜日"}
+
+func macroToday(ctx *mdContext, _ string) (string, error) {
+	return ctx.now.Format("2006-01-02"), nil
+}
+
+func macroWeekday(ctx *mdContext, _ string) (string, error) {
+	return japaneseWeekdays[ctx.now.Weekday()], nil
+}
+
+func macroShift(ctx *mdContext, _ string) (string, error) {
+	return ctx.shifts.at(ctx.now).Name(), nil
+}
+
+func macroUpdated(ctx *mdContext, _ string) (string, error) {
+	if ctx.updated.IsZero() {
+		return "", fmt.Errorf("更新日時がわかりません")
+	}
+	return ctx.updated.Format("2006-01-02 15:04"), nil
+}
+
+func macroPageTitle(ctx *mdContext, _ string) (string, error) {
+	if ctx.title == "" {
+		return "", fmt.Errorf("ページのタイトルがわかりません")
+	}
+	return ctx.title, nil
+}
+
+// macroChildren は目次でこのページの下にあるページの一覧を書き出す。
+func (r *mdRenderer) macroChildren(_ string) error {
+	if len(r.ctx.children) == 0 {
+		return fmt.Errorf("目次でこのページの下にあるページはありません")
+	}
+	r.cr()
+	r.b.WriteString(`<ul class="children">` + "\n")
+	for _, child := range r.ctx.children {
+		r.b.WriteString(`<li><a href="` + escapeHTML(safeURL(child.Href)) + `">` + escapeHTML(child.Title) + "</a></li>\n")
+	}
+	r.b.WriteString("</ul>\n")
+	return nil
+}
+
+func (r *mdRenderer) macroInclude(arg string) error {
+	slug, section, _ := strings.Cut(strings.TrimSpace(arg), "#")
+	return r.include(slug, section)
+}
+
+// blockMacro は段落がマクロ1つだけなら、その名前と引数を返す。
+func blockMacro(n *mdNode) (name, arg string, ok bool) {
+	if n.kind != mdParagraph {
+		return "", "", false
+	}
+	m := reBlockMacro.FindStringSubmatch(n.content)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// renderBlockMacro は1行に単独で書いたマクロのうち、ブロックを書き出すものを処理する。
+// そうでなければ false を返し、段落として描画する。
+func (r *mdRenderer) renderBlockMacro(n *mdNode) bool {
+	name, arg, ok := blockMacro(n)
+	if !ok {
+		return false
+	}
+	m, ok := macros[name]
+	if !ok || m.block == nil {
+		return false
+	}
+	var err error
+	if r.ctx == nil {
+		err = fmt.Errorf("{{%s}} はここでは使えません", name)
+	} else {
+		err = m.block(r, arg)
+	}
+	if err != nil {
+		r.cr()
+		r.b.WriteString(`<div class="macro-error">` + escapeHTML(err.Error()) + "</div>\n")
+	}
+	return true
+}
+
+// parseMacro は文中の {{名前 引数}} を読む。
+func (p *mdInlineParser) parseMacro(block *mdInline) bool {
+	m := reMacro.FindStringSubmatch(p.s[p.pos:])
+	if m == nil {
+		return false
+	}
+	p.pos += len(m[0])
+	block.appendChild(&mdInline{kind: inMacro, dest: m[1], title: m[2], literal: m[0]})
+	return true
+}
+
+// writeMacro は文中のマクロの値を書き出す。
+func (r *mdRenderer) writeMacro(n *mdInline) {
+	value, err := r.macroValue(n.dest, n.title)
+	if err != nil {
+		r.b.WriteString(`<span class="macro-error">` + escapeHTML(n.literal) + ": " + escapeHTML(err.Error()) + "</span>")
+		return
+	}
+	r.b.WriteString(escapeHTML(value))
+}
+
+func (r *mdRenderer) macroValue(name, arg string) (string, error) {
+	m, ok := macros[name]
+	switch {
+	case !ok:
+		return "", fmt.Errorf("不明なマクロです")
+	case m.value == nil:
+		return "", fmt.Errorf("1行に単独で書いてください")
+	case r.ctx == nil:
+		return "", fmt.Errorf("ここでは使えません")
+	}
+	if m.volatile {
+		r.volatile = true
+	}
+	return m.value(r.ctx, arg)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// GitHub 形式の表。見出し行・区切り行 (|---|:---:|) の後に、空行か別のブロックまでが行になる。
+// 日本語入力で入りやすい全角の ｜ も区切りとして扱う。
+
+type mdTableData struct {
+	aligns []string
+	header []string
+	rows   [][]string
+}
+
+var reTableDelimiterCell = regexp.MustCompile(`^:?-+:?$`)
+
+// isTableDelimiterRow は | を含み、すべてのセルが区切り (---、:--、--:、:-:) の行かどうかを返す。
+func isTableDelimiterRow(line string) bool {
+	if !strings.ContainsAny(line, "|｜") {
+		return false
+	}
+	cells := splitTableRow(line)
+	if len(cells) == 0 {
+		return false
+	}
+	for _, cell := range cells {
+		if !reTableDelimiterCell.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// tableHeaderMatches は段落の最後の行が、区切り行と同じ列数の見出し行かどうかを返す。
+func (p *mdParser) tableHeaderMatches(para *mdNode, delimiter string) bool {
+	if len(para.lines) == 0 {
+		return false
+	}
+	header := para.lines[len(para.lines)-1]
+	return len(splitTableRow(header)) == len(splitTableRow(delimiter))
+}
+
+// interruptsTable は表の途中で別のブロックが始まる行かどうかを返す。
+func interruptsTable(s string) bool {
+	switch {
+	case strings.HasPrefix(s, ">"),
+		reATXHeading.MatchString(s),
+		reCodeFence.MatchString(s),
+		reCalloutFence.MatchString(s),
+		reThematicBreak.MatchString(s):
+		return true
+	}
+	if m := reBulletMarker.FindString(s); m != "" {
+		return len(s) == 1 || s[1] == ' '
+	}
+	if m := reOrderedMarker.FindString(s); m != "" {
+		return len(s) == len(m) || s[len(m)] == ' '
+	}
+	return false
+}
+
+// splitTableRow は行をセルに分ける。前後の | は省略でき、\| はセル内の | になる。
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	var (
+		cells []string
+		cell  strings.Builder
+	)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && (runes[i+1] == '|' || runes[i+1] == '｜'):
+			// コードの中でもエスケープを外して | にする
+			i++
+			cell.WriteRune(runes[i])
+		case r == '|' || r == '｜':
+			if i > 0 {
+				cells = append(cells, strings.TrimSpace(cell.String()))
+			}
+			cell.Reset()
+		default:
+			cell.WriteRune(r)
+		}
+	}
+	if last := strings.TrimSpace(cell.String()); last != "" || len(runes) > 0 && !isTablePipe(runes[len(runes)-1]) {
+		cells = append(cells, last)
+	}
+	return cells
+}
+
+func isTablePipe(r rune) bool {
+	return r == '|' || r == '｜'
+}
+
+// parseTable は見出し行・区切り行・本体の行から表を組み立てる。
+// 本体の行のセルは見出しの列数に合わせて、足りなければ空にし、多ければ捨てる。
+func parseTable(lines []string) *mdTableData {
+	t := &mdTableData{header: splitTableRow(lines[0])}
+	for _, cell := range splitTableRow(lines[1]) {
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			t.aligns = append(t.aligns, "center")
+		case right:
+			t.aligns = append(t.aligns, "right")
+		case left:
+			t.aligns = append(t.aligns, "left")
+		default:
+			t.aligns = append(t.aligns, "")
+		}
+	}
+	for _, line := range lines[2:] {
+		cells := splitTableRow(line)
+		row := make([]string, len(t.header))
+		copy(row, cells)
+		t.rows = append(t.rows, row)
+	}
+	return t
+}
+
+func (r *mdRenderer) renderTable(t *mdTableData) {
+	r.b.WriteString("<table>\n<thead>\n")
+	r.renderTableRow("th", t.header, t.aligns)
+	r.b.WriteString("</thead>\n")
+	if len(t.rows) > 0 {
+		r.b.WriteString("<tbody>\n")
+		for _, row := range t.rows {
+			r.renderTableRow("td", row, t.aligns)
+		}
+		r.b.WriteString("</tbody>\n")
+	}
+	r.b.WriteString("</table>\n")
+}
+
+func (r *mdRenderer) renderTableRow(tag string, cells, aligns []string) {
+	r.b.WriteString("<tr>\n")
+	for i, cell := range cells {
+		r.b.WriteString("<" + tag)
+		if aligns[i] != "" {
+			r.b.WriteString(` align="` + aligns[i] + `"`)
+		}
+		r.b.WriteString(">")
+		r.renderInline(cell)
+		r.b.WriteString("</" + tag + ">\n")
+	}
+	r.b.WriteString("</tr>\n")
+}
@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+// CommonMark の仕様の例 (https://spec.commonmark.org/) と、その結果。
+// 仕様と違うのは次の3点で、期待する結果もそれに合わせている。
+//   - 見出しに id を付ける (ページ内の目次に使う)
+//   - 生の HTML は書けず、文字として表示する
+//   - javascript: などスクリプトを実行できるリンク先は空にする
+var commonMarkExamples = []struct {
+	section string
+	md      string
+	want    string
+}{
+	// 強調
+	{"emphasis", "*foo bar*\n", "<p><em>foo bar</em></p>\n"},
+	{"emphasis", "a * foo bar*\n", "<p>a * foo bar*</p>\n"},
+	{"emphasis", "_foo_bar\n", "<p>_foo_bar</p>\n"},
+	{"emphasis", "foo*bar*\n", "<p>foo<em>bar</em></p>\n"},
+	{"emphasis", "**foo bar**\n", "<p><strong>foo bar</strong></p>\n"},
+	{"emphasis", "*foo **bar** baz*\n", "<p><em>foo <strong>bar</strong> baz</em></p>\n"},
+	{"emphasis", "**foo *bar* baz**\n", "<p><strong>foo <em>bar</em> baz</strong></p>\n"},
+	{"emphasis", "*foo**bar**baz*\n", "<p><em>foo<strong>bar</strong>baz</em></p>\n"},
+	{"emphasis", "*foo**bar*\n", "<p><em>foo**bar</em></p>\n"},
+	{"emphasis", "***strong emph***\n", "<p><em><strong>strong emph</strong></em></p>\n"},
+	{"emphasis", "***foo** bar*\n", "<p><em><strong>foo</strong> bar</em></p>\n"},
+	{"emphasis", "**foo*\n", "<p>*<em>foo</em></p>\n"},
+	{"emphasis", "**foo**bar**\n", "<p><strong>foo</strong>bar**</p>\n"},
+
+	// リスト
+	{"lists", "- foo\n- bar\n- baz\n", "<ul>\n<li>foo</li>\n<li>bar</li>\n<li>baz</li>\n</ul>\n"},
+	{"lists", "- foo\n\n- bar\n", "<ul>\n<li>\n<p>foo</p>\n</li>\n<li>\n<p>bar</p>\n</li>\n</ul>\n"},
+	{"lists", "- a\n- b\n\n- c\n", "<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n<li>\n<p>c</p>\n</li>\n</ul>\n"},
+	{"lists", "- a\n  - b\n\n    c\n- d\n", "<ul>\n<li>a\n<ul>\n<li>\n<p>b</p>\n<p>c</p>\n</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n"},
+	{"lists", "- foo\n\n\n  bar\n", "<ul>\n<li>\n<p>foo</p>\n<p>bar</p>\n</li>\n</ul>\n"},
+	{"lists", "- a\n  - b\n    - c\n", "<ul>\n<li>a\n<ul>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n</ul>\n</li>\n</ul>\n"},
+	{"lists", "1. a\n2. b\n", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+	{"lists", "3. foo\n4. bar\n", "<ol start=\"3\">\n<li>foo</li>\n<li>bar</li>\n</ol>\n"},
+	{"lists", "0. ok\n", "<ol start=\"0\">\n<li>ok</li>\n</ol>\n"},
+	{"lists", "123456789. ok\n", "<ol start=\"123456789\">\n<li>ok</li>\n</ol>\n"},
+	{"lists", "1234567890. not ok\n", "<p>1234567890. not ok</p>\n"},
+	{"lists", "1. foo\n2.\n3. bar\n", "<ol>\n<li>foo</li>\n<li></li>\n<li>bar</li>\n</ol>\n"},
+	{"lists", "1) a\n\n   b\n", "<ol>\n<li>\n<p>a</p>\n<p>b</p>\n</li>\n</ol>\n"},
+	{"lists", "- Foo\n---\n", "<ul>\n<li>Foo</li>\n</ul>\n<hr />\n"},
+
+	// フェンスのコードブロック
+	{"fenced code", "```\n<\n >\n```\n", "<pre><code>&lt;\n &gt;\n</code></pre>\n"},
+	{"fenced code", "```ruby\ndef foo(x)\n  return 3\nend\n```\n", "<pre><code class=\"language-ruby\">def foo(x)\n  return 3\nend\n</code></pre>\n"},
+	{"fenced code", "~~~\naaa\n```\n~~~\n", "<pre><code>aaa\n```\n</code></pre>\n"},
+	{"fenced code", "```\naaa\n", "<pre><code>aaa\n</code></pre>\n"},
+	{"fenced code", "  ```\n  aaa\naaa\n  ```\n", "<pre><code>aaa\naaa\n</code></pre>\n"},
+	{"fenced code", "``` ```\naaa\n", "<p><code> </code>\naaa</p>\n"},
+
+	// インデントのコードブロック
+	{"indented code", "    a simple\n      indented code block\n", "<pre><code>a simple\n  indented code block\n</code></pre>\n"},
+	{"indented code", "    foo\nbar\n", "<pre><code>foo\n</code></pre>\n<p>bar</p>\n"},
+
+	// リンクとリンク参照定義
+	{"links", "[link](/uri \"title\")\n", "<p><a href=\"/uri\" title=\"title\">link</a></p>\n"},
+	{"links", "[link](</my uri>)\n", "<p><a href=\"/my%20uri\">link</a></p>\n"},
+	{"links", "[a](<b)c>)\n", "<p><a href=\"b)c\">a</a></p>\n"},
+	{"links", "[link](foo\\)\\:)\n", "<p><a href=\"foo):\">link</a></p>\n"},
+	{"links", "[link [foo [bar]]](/uri)\n", "<p><a href=\"/uri\">link [foo [bar]]</a></p>\n"},
+	{"links", "[foo *bar*](/u)\n", "<p><a href=\"/u\">foo <em>bar</em></a></p>\n"},
+	{"links", "[foo](/url \"title\" \"x\")\n", "<p>[foo](/url &quot;title&quot; &quot;x&quot;)</p>\n"},
+	{"links", "![foo](/url \"title\")\n", "<p><img src=\"/url\" alt=\"foo\" title=\"title\" /></p>\n"},
+	{"links", "<http://foo.bar.baz>\n", "<p><a href=\"http://foo.bar.baz\">http://foo.bar.baz</a></p>\n"},
+	{"reference definitions", "[foo]\n\n[foo]: /url \"title\"\n", "<p><a href=\"/url\" title=\"title\">foo</a></p>\n"},
+	{"reference definitions", "[foo]: /url '<title>'\n\n[foo]\n", "<p><a href=\"/url\" title=\"&lt;title&gt;\">foo</a></p>\n"},
+	{"reference definitions", "[FOO]: /url\n\n[Foo]\n", "<p><a href=\"/url\">Foo</a></p>\n"},
+	{"reference definitions", "[Foo][]\n\n[foo]: /url\n", "<p><a href=\"/url\">Foo</a></p>\n"},
+	{"reference definitions", "[foo]: /url\n", ""},
+
+	// Setext 見出し
+	{"setext headings", "Foo *bar*\n=========\n", "<h1 id=\"foo-bar\">Foo <em>bar</em></h1>\n"},
+	{"setext headings", "Foo\n---\n", "<h2 id=\"foo\">Foo</h2>\n"},
+	{"setext headings", "Foo\nbar\n---\n", "<h2 id=\"foo-bar\">Foo\nbar</h2>\n"},
+	{"setext headings", "Foo\n= =\n", "<p>Foo\n= =</p>\n"},
+	{"atx headings", "# foo\n## foo\n", "<h1 id=\"foo\">foo</h1>\n<h2 id=\"foo-1\">foo</h2>\n"},
+
+	// 改行
+	{"hard line breaks", "foo  \nbaz\n", "<p>foo<br />\nbaz</p>\n"},
+	{"hard line breaks", "foo\\\nbaz\n", "<p>foo<br />\nbaz</p>\n"},
+	{"hard line breaks", "foo  \n", "<p>foo</p>\n"},
+	{"hard line breaks", "foo\\\n", "<p>foo\\</p>\n"},
+	{"hard line breaks", "`code  \nspan`\n", "<p><code>code   span</code></p>\n"},
+	{"soft line breaks", "foo\nbaz\n", "<p>foo\nbaz</p>\n"},
+
+	// HTML のエスケープ
+	{"escaping", "a < b & c > \"d\"\n", "<p>a &lt; b &amp; c &gt; &quot;d&quot;</p>\n"},
+	{"escaping", "<div>*x*</div>\n", "<p>&lt;div&gt;<em>x</em>&lt;/div&gt;</p>\n"},
+	{"escaping", "`<a>`\n", "<p><code>&lt;a&gt;</code></p>\n"},
+	{"escaping", "&copy; &#35; &#x22;\n", "<p>© # &quot;</p>\n"},
+	{"escaping", "a &lt;b&gt;\n", "<p>a &lt;b&gt;</p>\n"},
+	{"escaping", "\\*not emph\\*\n", "<p>*not emph*</p>\n"},
+	{"escaping", "[x](/a \"t\\\"<b>\")\n", "<p><a href=\"/a\" title=\"t&quot;&lt;b&gt;\">x</a></p>\n"},
+
+	// スクリプトを実行できるリンク先
+	{"unsafe urls", "[link](javascript:alert(1))\n", "<p><a href=\"\">link</a></p>\n"},
+	{"unsafe urls", "[a](JAVASCRIPT:x)\n", "<p><a href=\"\">a</a></p>\n"},
+	{"unsafe urls", "[x](<javascript:alert(1)>)\n", "<p><a href=\"\">x</a></p>\n"},
+	{"unsafe urls", "![x](javascript:alert(1))\n", "<p><img src=\"\" alt=\"x\" /></p>\n"},
+	{"unsafe urls", "<javascript:alert(1)>\n", "<p><a href=\"\">javascript:alert(1)</a></p>\n"},
+	{"unsafe urls", "[x](data:text/html,<script>)\n", "<p><a href=\"\">x</a></p>\n"},
+
+	// その他のブロック
+	{"block quotes", "> quote\n> more\n", "<blockquote>\n<p>quote\nmore</p>\n</blockquote>\n"},
+	{"thematic breaks", "***\n", "<hr />\n"},
+}
+
+func TestMarkdownCommonMarkExamples(t *testing.T) {
+	for _, ex := range commonMarkExamples {
+		if got := string(markdownToHTML(ex.md, nil)); got != ex.want {
+			t.Errorf("%s: %q\n got  %q\n want %q", ex.section, ex.md, got, ex.want)
+		}
+	}
+}
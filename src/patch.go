@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// handlePagePatch は /pages/<slug>/diff.patch?from=&to= で、指定ページの
+// 2つのコミット間の差分を unified diff 形式で返す。
+// to を省略すると HEAD、from を省略すると to の直前のコミットと比較する。
+func (a *app) handlePagePatch(w http.ResponseWriter, r *http.Request, slug string) {
+	if a.repo == nil {
+		http.Error(w, "パッチを作成するには Git が必要です。", http.StatusServiceUnavailable)
+		return
+	}
+	meta, ok := a.lookupPage(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	toRev := strings.TrimSpace(q.Get("to"))
+	if toRev == "" {
+		toRev = "HEAD"
+	}
+	to, err := a.resolveCommit(toRev)
+	if err != nil {
+		http.Error(w, "比較先の履歴が見つかりません", http.StatusNotFound)
+		return
+	}
+
+	var from *object.Commit
+	if fromRev := strings.TrimSpace(q.Get("from")); fromRev != "" {
+		from, err = a.resolveCommit(fromRev)
+		if err != nil {
+			http.Error(w, "比較元の履歴が見つかりません", http.StatusNotFound)
+			return
+		}
+	} else if to.NumParents() > 0 {
+		from, err = to.Parent(0)
+		if err != nil {
+			http.Error(w, "比較元の履歴が見つかりません", http.StatusNotFound)
+			return
+		}
+	}
+
+	patch, err := diffCommits(from, to, meta.GitPath)
+	if err != nil {
+		log.Printf("パッチの作成に失敗しました: %v", err)
+		http.Error(w, "パッチを作成できませんでした", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := patch.Encode(&buf); err != nil {
+		log.Printf("パッチの作成に失敗しました: %v", err)
+		http.Error(w, "パッチを作成できませんでした", http.StatusInternalServerError)
+		return
+	}
+
+	writePatch(w, slug+".patch", buf.Bytes())
+}
+
+// handleCommitPatch は /commits/<hash>.patch で、コミット全体の変更を
+// git am で適用できる形式 (git format-patch 相当) で返す。
+func (a *app) handleCommitPatch(w http.ResponseWriter, r *http.Request, rev string) {
+	if a.repo == nil {
+		http.Error(w, "パッチを作成するには Git が必要です。", http.StatusServiceUnavailable)
+		return
+	}
+	commit, err := a.resolveCommit(rev)
+	if err != nil {
+		http.Error(w, "指定の履歴が見つかりません", http.StatusNotFound)
+		return
+	}
+
+	var parent *object.Commit
+	if commit.NumParents() > 0 {
+		parent, err = commit.Parent(0)
+		if err != nil {
+			http.Error(w, "親コミットが見つかりません", http.StatusNotFound)
+			return
+		}
+	}
+
+	patch, err := diffCommits(parent, commit)
+	if err != nil {
+		log.Printf("パッチの作成に失敗しました: %v", err)
+		http.Error(w, "パッチを作成できませんでした", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	writeFormatPatchHeader(&buf, commit)
+	if err := patch.Encode(&buf); err != nil {
+		log.Printf("パッチの作成に失敗しました: %v", err)
+		http.Error(w, "パッチを作成できませんでした", http.StatusInternalServerError)
+		return
+	}
+	buf.WriteString("-- \nLFWiki\n")
+
+	writePatch(w, commit.Hash.String()[:12]+".patch", buf.Bytes())
+}
+
+func (a *app) resolveCommit(rev string) (*object.Commit, error) {
+	if a.repo == nil {
+		return nil, errNoRepo
+	}
+	hash, err := a.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, err
+	}
+	return a.repo.CommitObject(*hash)
+}
+
+// diffCommits は from から to への変更を返す。from が nil の場合は空のツリーと比較する。
+// paths を指定すると、そのファイルに関する変更だけに絞り込む。
+func diffCommits(from, to *object.Commit, paths ...string) (*object.Patch, error) {
+	var fromTree, toTree *object.Tree
+	var err error
+	if from != nil {
+		if fromTree, err = from.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	if to != nil {
+		if toTree, err = to.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > 0 {
+		changes = filterChanges(changes, paths)
+	}
+	return changes.Patch()
+}
+
+func filterChanges(changes object.Changes, paths []string) object.Changes {
+	wanted := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		wanted[p] = true
+	}
+	filtered := make(object.Changes, 0, len(changes))
+	for _, change := range changes {
+		if wanted[change.From.Name] || wanted[change.To.Name] {
+			filtered = append(filtered, change)
+		}
+	}
+	return filtered
+}
+
+func writeFormatPatchHeader(buf *bytes.Buffer, commit *object.Commit) {
+	subject, body, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+	if subject == "" {
+		subject = "更新"
+	}
+
+	fmt.Fprintf(buf, "From %s Mon Sep 17 00:00:00 2001\n", commit.Hash)
+	fmt.Fprintf(buf, "From: %s <%s>\n", mime.QEncoding.Encode("utf-8", commit.Author.Name), commit.Author.Email)
+	fmt.Fprintf(buf, "Date: %s\n", commit.Author.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(buf, "Subject: [PATCH] %s\n", mime.QEncoding.Encode("utf-8", subject))
+	buf.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n\n")
+	if body = strings.TrimSpace(body); body != "" {
+		buf.WriteString(body + "\n")
+	}
+	buf.WriteString("---\n\n")
+}
+
+func writePatch(w http.ResponseWriter, filename string, data []byte) {
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Write(data)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// writeTimeout は保存や同期の1回の書き込みが順番を待つ上限。
+const writeTimeout = 30 * time.Second
+
+var errWriteTimeout = errors.New("書き込みの順番待ちがタイムアウトしました")
+
+// writeQueue はマニュアルのファイル書き込みと Git の更新操作を1つのゴルーチンで
+// 順番に実行する。同時に保存されても、ファイルの書き込みからコミットまでが
+// 他の操作と混ざらず、コミットは受け付けた順に並ぶ。
+type writeQueue struct {
+	jobs chan func()
+}
+
+type writeResult[T any] struct {
+	value T
+	err   error
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{jobs: make(chan func())}
+	go q.run()
+	return q
+}
+
+func (q *writeQueue) run() {
+	for job := range q.jobs {
+		job()
+	}
+}
+
+// queueWrite は fn を書き込み用のゴルーチンで実行し、その結果を返す。
+// ctx を見るのは順番を待っている間だけで、待ちきれなければ fn を実行せずに errWriteTimeout を返す。
+// 実行が始まった fn は、呼び出し元の ctx が終わっても最後まで実行し、その結果を返す。
+func queueWrite[T any](ctx context.Context, q *writeQueue, fn func() (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	var zero T
+	if ctx.Err() != nil {
+		return zero, errWriteTimeout
+	}
+	done := make(chan writeResult[T], 1)
+	job := func() {
+		value, err := fn()
+		done <- writeResult[T]{value: value, err: err}
+	}
+	select {
+	case q.jobs <- job:
+	case <-ctx.Done():
+		return zero, errWriteTimeout
+	}
+	res := <-done
+	return res.value, res.err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueWriteWaitsForRunningJob(t *testing.T) {
+	q := newWriteQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// 実行中に ctx が切れても、fn の結果を受け取るまで戻らない
+	got, err := queueWrite(ctx, q, func() (int, error) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		return 42, nil
+	})
+	if err != nil || got != 42 {
+		t.Fatalf("queueWrite = %d, %v; want 42, nil", got, err)
+	}
+}
+
+func TestQueueWriteTimesOutWhileWaiting(t *testing.T) {
+	q := newWriteQueue()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go queueWrite(context.Background(), q, func() (struct{}, error) {
+		close(started)
+		<-release
+		return struct{}{}, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ran := false
+	_, err := queueWrite(ctx, q, func() (struct{}, error) {
+		ran = true
+		return struct{}{}, nil
+	})
+	if !errors.Is(err, errWriteTimeout) {
+		t.Fatalf("err = %v; want errWriteTimeout", err)
+	}
+	close(release)
+
+	// 後の操作が順番どおりに実行されれば、タイムアウトした fn は実行されていない
+	if _, err := queueWrite(context.Background(), q, func() (struct{}, error) { return struct{}{}, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Fatal("順番待ちでタイムアウトした操作が実行されました")
+	}
+}
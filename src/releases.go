@@ -0,0 +1,536 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	errReleaseExists  = errors.New("同じ名前のリリースがすでにあります")
+	errInvalidRelease = errors.New("リリース名には英数字と . _ - だけを使えます (先頭は英数字)")
+)
+
+var releaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// release は承認済みの版として付けた Git のタグ。
+type release struct {
+	Name        string
+	Description string
+	Tagger      string
+	Date        string
+	Hash        string
+	ShortHash   string
+	when        time.Time
+}
+
+type releaseForm struct {
+	Name        string
+	Description string
+	Tagger      string
+	Rev         string
+}
+
+type releaseChangelog struct {
+	From    string
+	To      string
+	Entries []changelogEntry
+	Pages   []changelogPage
+}
+
+type changelogEntry struct {
+	Hash      string
+	ShortHash string
+	Subject   string
+	Author    string
+	Date      string
+	Pages     []string
+}
+
+type changelogPage struct {
+	Title string
+	Link  string
+	Count int
+}
+
+func releasePageLink(name, slug string) string {
+	if slug == "top" {
+		return "/at/" + url.PathEscape(name) + "/"
+	}
+	return "/at/" + url.PathEscape(name) + "/pages/" + slug
+}
+
+func (a *app) listReleases() ([]release, error) {
+	if a.repo == nil {
+		return nil, errNoRepo
+	}
+	iter, err := a.repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var releases []release
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		rel := release{Name: ref.Name().Short()}
+		commitHash := ref.Hash()
+		if tag, err := a.repo.TagObject(ref.Hash()); err == nil {
+			rel.Description = strings.TrimSpace(tag.Message)
+			rel.Tagger = tag.Tagger.Name
+			rel.when = tag.Tagger.When
+			commitHash = tag.Target
+		} else if !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+		commit, err := a.repo.CommitObject(commitHash)
+		if err != nil {
+			// コミット以外を指すタグはリリースとして扱わない
+			return nil
+		}
+		if rel.when.IsZero() {
+			rel.when = commit.Committer.When
+		}
+		rel.Date = rel.when.Format("2006-01-02 15:04")
+		rel.Hash = commit.Hash.String()
+		rel.ShortHash = rel.Hash[:7]
+		releases = append(releases, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(releases, func(i, j int) bool { return releases[i].when.After(releases[j].when) })
+	return releases, nil
+}
+
+// createRelease は rev (省略時は HEAD) に注釈付きタグを付ける。
+func (a *app) createRelease(form releaseForm) (release, error) {
+	if a.repo == nil {
+		return release{}, errNoRepo
+	}
+	if !releaseNamePattern.MatchString(form.Name) || strings.Contains(form.Name, "..") || strings.HasSuffix(form.Name, ".lock") {
+		return release{}, errInvalidRelease
+	}
+	if _, err := a.repo.Tag(form.Name); err == nil {
+		return release{}, errReleaseExists
+	} else if !errors.Is(err, git.ErrTagNotFound) {
+		return release{}, err
+	}
+
+	rev := form.Rev
+	if rev == "" {
+		rev = "HEAD"
+	}
+	commit, err := a.resolveCommit(rev)
+	if err != nil {
+		return release{}, fmt.Errorf("リリースにする版が見つかりません: %w", err)
+	}
+
+	tagger := form.Tagger
+	if tagger == "" {
+		tagger = "マニュアル編集者"
+	}
+	signer := a.editorFor(tagger)
+	description := form.Description
+	if description == "" {
+		description = form.Name
+	}
+	now := time.Now()
+	_, err = a.repo.CreateTag(form.Name, commit.Hash, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: signer.Name, Email: signer.Email, When: now},
+		Message: description,
+	})
+	if err != nil {
+		return release{}, err
+	}
+
+	hash := commit.Hash.String()
+	return release{
+		Name:        form.Name,
+		Description: description,
+		Tagger:      signer.Name,
+		Date:        now.Format("2006-01-02 15:04"),
+		Hash:        hash,
+		ShortHash:   hash[:7],
+		when:        now,
+	}, nil
+}
+
+// resolveRelease はタグ名からリリースしたコミットを探す。
+func (a *app) resolveRelease(name string) (*object.Commit, error) {
+	if a.repo == nil {
+		return nil, errNoRepo
+	}
+	ref, err := a.repo.Tag(name)
+	if err != nil {
+		return nil, err
+	}
+	if tag, err := a.repo.TagObject(ref.Hash()); err == nil {
+		return tag.Commit()
+	}
+	return a.repo.CommitObject(ref.Hash())
+}
+
+// buildChangelog は from のリリースから to のリリース (省略時は HEAD) までに
+// 加わったコミットを新しい順に集める。同期で作られるマージコミットは含めない。
+func (a *app) buildChangelog(from, to string) (*releaseChangelog, error) {
+	if a.repo == nil {
+		return nil, errNoRepo
+	}
+
+	var (
+		toCommit *object.Commit
+		err      error
+	)
+	if to == "" {
+		toCommit, err = a.resolveCommit("HEAD")
+	} else {
+		toCommit, err = a.resolveRelease(to)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("比較先のリリースが見つかりません: %w", err)
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	if from != "" {
+		fromCommit, err := a.resolveRelease(from)
+		if err != nil {
+			return nil, fmt.Errorf("比較元のリリースが見つかりません: %w", err)
+		}
+		iter, err := a.repo.Log(&git.LogOptions{From: fromCommit.Hash})
+		if err != nil {
+			return nil, err
+		}
+		err = iter.ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return nil
+		})
+		iter.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slugByPath := make(map[string]string)
+	pages, _, err := a.loadManualIndexAt(toCommit.Hash.String())
+	if err != nil {
+		pages, _ = a.manualIndex()
+	}
+	for slug, meta := range pages {
+		slugByPath[meta.GitPath] = slug
+	}
+
+	changelog := &releaseChangelog{From: from, To: to}
+	pageCounts := make(map[string]int)
+
+	iter, err := a.repo.Log(&git.LogOptions{From: toCommit.Hash, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	err = iter.ForEach(func(c *object.Commit) error {
+		if seen[c.Hash] || c.NumParents() > 1 {
+			return nil
+		}
+		var parentTree *object.Tree
+		if c.NumParents() == 1 {
+			p, err := c.Parent(0)
+			if err != nil {
+				return err
+			}
+			if parentTree, err = p.Tree(); err != nil {
+				return err
+			}
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+
+		subject := strings.Split(c.Message, "\n")[0]
+		if subject == "" {
+			subject = "更新"
+		}
+		hash := c.Hash.String()
+		entry := changelogEntry{
+			Hash:      hash,
+			ShortHash: hash[:7],
+			Subject:   subject,
+			Author:    c.Author.Name,
+			Date:      c.Author.When.Format("2006-01-02 15:04"),
+		}
+		for _, change := range changes {
+			name := change.To.Name
+			if name == "" {
+				name = change.From.Name
+			}
+			slug, ok := slugByPath[name]
+			if !ok {
+				continue
+			}
+			entry.Pages = append(entry.Pages, pages[slug].Title)
+			pageCounts[slug]++
+		}
+		changelog.Entries = append(changelog.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for slug, count := range pageCounts {
+		link := makePageLink(slug)
+		if to != "" {
+			link = releasePageLink(to, slug)
+		}
+		changelog.Pages = append(changelog.Pages, changelogPage{Title: pages[slug].Title, Link: link, Count: count})
+	}
+	sort.Slice(changelog.Pages, func(i, j int) bool {
+		if changelog.Pages[i].Count != changelog.Pages[j].Count {
+			return changelog.Pages[i].Count > changelog.Pages[j].Count
+		}
+		return changelog.Pages[i].Title < changelog.Pages[j].Title
+	})
+	return changelog, nil
+}
+
+func (a *app) handleReleases(w http.ResponseWriter, r *http.Request) {
+	view := pageView{
+		Mode:      "releases",
+		SiteTitle: siteTitle,
+		PageTitle: "リリース",
+		TOC:       a.currentTOC(),
+	}
+	if a.repo == nil {
+		view.Flash = &flashMessage{Type: "error", Message: "リリースを作成するには Git が必要です。"}
+		a.render(w, view)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if name := r.URL.Query().Get("created"); name != "" {
+			view.Flash = &flashMessage{Type: "success", Message: fmt.Sprintf("リリース「%s」を作成しました。", name)}
+		}
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "フォームの解析に失敗しました", http.StatusBadRequest)
+			return
+		}
+		form := releaseForm{
+			Name:        strings.TrimSpace(r.PostFormValue("name")),
+			Description: strings.TrimSpace(strings.ReplaceAll(r.PostFormValue("description"), "\r\n", "\n")),
+			Tagger:      strings.TrimSpace(r.PostFormValue("tagger")),
+			Rev:         strings.TrimSpace(r.PostFormValue("rev")),
+		}
+
+		created, err := queueWrite(r.Context(), a.writes, func() (release, error) {
+			return a.createRelease(form)
+		})
+		if err == nil {
+			if a.sync != nil {
+				a.sync.trigger()
+			}
+			http.Redirect(w, r, "/releases?created="+url.QueryEscape(created.Name), http.StatusSeeOther)
+			return
+		}
+
+		var msg string
+		switch {
+		case errors.Is(err, errInvalidRelease), errors.Is(err, errReleaseExists):
+			msg = err.Error()
+		case errors.Is(err, errWriteTimeout):
+			msg = "他の保存や同期の処理が混み合っているため、作成できませんでした。"
+		default:
+			log.Printf("リリースの作成に失敗しました: %v", err)
+			msg = "リリースを作成できませんでした: " + err.Error()
+		}
+		view.Flash = &flashMessage{Type: "error", Message: msg}
+		view.ReleaseForm = form
+	default:
+		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+		return
+	}
+
+	releases, err := a.listReleases()
+	if err != nil {
+		log.Printf("リリースの一覧を取得できませんでした: %v", err)
+	}
+	view.Releases = releases
+	a.render(w, view)
+}
+
+func (a *app) handleReleaseChangelog(w http.ResponseWriter, r *http.Request) {
+	if a.repo == nil {
+		http.Error(w, "変更点をまとめるには Git が必要です。", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	from := strings.TrimSpace(q.Get("from"))
+	to := strings.TrimSpace(q.Get("to"))
+
+	view := pageView{
+		Mode:      "changelog",
+		SiteTitle: siteTitle,
+		PageTitle: "リリース間の変更点",
+		TOC:       a.currentTOC(),
+	}
+	releases, err := a.listReleases()
+	if err != nil {
+		log.Printf("リリースの一覧を取得できませんでした: %v", err)
+	}
+	view.Releases = releases
+
+	changelog, err := a.buildChangelog(from, to)
+	if err != nil {
+		view.Flash = &flashMessage{Type: "error", Message: err.Error()}
+		view.Changelog = &releaseChangelog{From: from, To: to}
+	} else {
+		view.Changelog = changelog
+	}
+	a.render(w, view)
+}
+
+// handleAt は /at/<リリース名>/ と /at/<リリース名>/pages/<slug> で、リリース時点のページを表示する。
+func (a *app) handleAt(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/at/")
+	name, pagePath, _ := strings.Cut(rest, "/")
+	if name == "" {
+		http.Redirect(w, r, "/releases", http.StatusSeeOther)
+		return
+	}
+
+	slug := "top"
+	if pagePath != "" {
+		s, ok := strings.CutPrefix(pagePath, "pages/")
+		if !ok || strings.Trim(s, "/") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		slug = strings.Trim(s, "/")
+	}
+
+	commit, err := a.resolveRelease(name)
+	if err != nil {
+		http.Error(w, "指定のリリースが見つかりません", http.StatusNotFound)
+		return
+	}
+
+	view, ok := a.buildPageViewAt(w, r, slug, commit.Hash.String(), func(slug, _ string) string {
+		return releasePageLink(name, slug)
+	})
+	if !ok {
+		return
+	}
+	view.Mode = "page"
+	view.Flash = &flashMessage{
+		Type:    "info",
+		Message: fmt.Sprintf("リリース「%s」の内容を表示しています。", name),
+	}
+	a.render(w, view)
+}
+
+// runTagCommand はコマンドラインからリリースを操作する。
+func (a *app) runTagCommand(args []string) error {
+	if a.repo == nil {
+		return errNoRepo
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("tag list / tag create / tag changelog のいずれかを指定してください")
+	}
+
+	switch args[0] {
+	case "list":
+		releases, err := a.listReleases()
+		if err != nil {
+			return err
+		}
+		for _, rel := range releases {
+			fmt.Printf("%s\t%s\t%s\t%s\n", rel.Name, rel.Date, rel.ShortHash, strings.Split(rel.Description, "\n")[0])
+		}
+		return nil
+
+	case "create":
+		fs := flag.NewFlagSet("tag create", flag.ExitOnError)
+		description := fs.String("m", "", "リリースの説明")
+		rev := fs.String("rev", "HEAD", "リリースにする版 (コミット)")
+		tagger := fs.String("tagger", "", "記録する名前")
+		fs.Usage = func() {
+			fmt.Fprintln(fs.Output(), "使い方: tag create [-m 説明] [-rev 版] [-tagger 名前] <リリース名>")
+			fs.PrintDefaults()
+		}
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		rel, err := a.createRelease(releaseForm{Name: fs.Arg(0), Description: *description, Tagger: *tagger, Rev: *rev})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("リリース %s を %s に作成しました\n", rel.Name, rel.ShortHash)
+		return nil
+
+	case "changelog":
+		rest := args[1:]
+		if len(rest) == 0 || len(rest) > 2 {
+			return fmt.Errorf("使い方: tag changelog <比較元> [<比較先>]")
+		}
+		from, to := rest[0], ""
+		if len(rest) == 2 {
+			to = rest[1]
+		}
+		changelog, err := a.buildChangelog(from, to)
+		if err != nil {
+			return err
+		}
+		writeChangelogText(os.Stdout, changelog)
+		return nil
+	}
+	return fmt.Errorf("不明なサブコマンドです: tag %s", args[0])
+}
+
+// writeChangelogText は変更点を Markdown で書き出す。
+func writeChangelogText(w io.Writer, changelog *releaseChangelog) {
+	to := changelog.To
+	if to == "" {
+		to = "現在"
+	}
+	fmt.Fprintf(w, "# %s → %s の変更点\n\n", changelog.From, to)
+	if len(changelog.Entries) == 0 {
+		fmt.Fprintln(w, "変更はありません。")
+		return
+	}
+	fmt.Fprintln(w, "## 変更されたページ")
+	fmt.Fprintln(w)
+	for _, page := range changelog.Pages {
+		fmt.Fprintf(w, "- %s (%d 件)\n", page.Title, page.Count)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## 更新履歴")
+	fmt.Fprintln(w)
+	for _, entry := range changelog.Entries {
+		line := fmt.Sprintf("- %s %s (%s, %s)", entry.Date, entry.Subject, entry.Author, entry.ShortHash)
+		if len(entry.Pages) > 0 {
+			line += ": " + strings.Join(entry.Pages, ", ")
+		}
+		fmt.Fprintln(w, line)
+	}
+}
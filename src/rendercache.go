@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// renderCache は作業コピーのページを描画した結果を覚えておく。ファイルの内容 (Git の blob のハッシュ)・
+// 描画の版・更新日時と、{{include}} で埋め込んだページの内容が描画したときと同じなら描画し直さない。
+// {{today}} など時刻で変わるマクロを使ったページは、日付かシフトが変わったら描画し直す。
+// 目次を読み直したときはすべて、保存したときはそのページと、それを埋め込んでいるページを捨てる。
+// 過去の版の表示はリンク先の書き換え方が画面ごとに違うため覚えない。
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[string]renderCacheEntry // 作業コピーのファイルのパスごと
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// rendererVersion は描画の仕方 (Markdown の変換やマクロ) を変えたら上げる。
+const rendererVersion = 1
+
+type renderCacheEntry struct {
+	slug      string
+	blob      plumbing.Hash
+	version   int
+	updatedAt time.Time
+	// deps は埋め込んだページの slug と、描画したときのその内容のハッシュ (読めなかったページはゼロ値)。
+	deps map[string]plumbing.Hash
+	// period は時刻で変わるマクロを使ったときの日付とシフト。使っていなければ空。
+	period string
+	page   manualPage
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{entries: make(map[string]renderCacheEntry)}
+}
+
+// renderPeriod は時刻で変わるマクロの値が変わらない間を表す文字列 (日付とシフト)。
+func renderPeriod(ctx *mdContext) string {
+	return ctx.now.Format("2006-01-02") + " " + ctx.shifts.at(ctx.now).Key()
+}
+
+func includeHash(md string, err error) plumbing.Hash {
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte(md))
+}
+
+// load は path のページを描画した結果を返す。覚えている結果が使えればそれを返す。
+func (c *renderCache) load(path string, ctx *mdContext) (manualPage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manualPage{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return manualPage{}, err
+	}
+	blob := plumbing.ComputeHash(plumbing.BlobObject, data)
+
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.valid(blob, info.ModTime(), ctx) {
+		c.hits.Add(1)
+		return entry.page, nil
+	}
+	c.misses.Add(1)
+
+	entry = renderCacheEntry{blob: blob, version: rendererVersion, updatedAt: info.ModTime(), deps: make(map[string]plumbing.Hash)}
+	if ctx != nil {
+		// 埋め込んだページを、そのときの内容のハッシュと一緒に記録する
+		recording := *ctx
+		if readPage := ctx.readPage; readPage != nil {
+			recording.readPage = func(slug string) (string, error) {
+				md, err := readPage(slug)
+				entry.deps[slug] = includeHash(md, err)
+				return md, err
+			}
+		}
+		entry.slug = ctx.slug
+		ctx = &recording
+	}
+	entry.page = manualPageFromMarkdown(data, info.ModTime(), ctx)
+	if entry.page.Volatile && ctx != nil {
+		entry.period = renderPeriod(ctx)
+	}
+
+	c.mu.Lock()
+	c.entries[path] = entry
+	c.mu.Unlock()
+	return entry.page, nil
+}
+
+func (e renderCacheEntry) valid(blob plumbing.Hash, updatedAt time.Time, ctx *mdContext) bool {
+	if e.blob != blob || e.version != rendererVersion || !e.updatedAt.Equal(updatedAt) {
+		return false
+	}
+	if ctx == nil {
+		return len(e.deps) == 0 && e.period == ""
+	}
+	if e.slug != ctx.slug || e.period != "" && e.period != renderPeriod(ctx) {
+		return false
+	}
+	for slug, hash := range e.deps {
+		if ctx.readPage == nil || includeHash(ctx.readPage(slug)) != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// forget は slugs のページと、それらを埋め込んでいるページの結果を捨てる。
+func (c *renderCache) forget(slugs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, entry := range c.entries {
+		if slices.Contains(slugs, entry.slug) {
+			delete(c.entries, path)
+			continue
+		}
+		for dep := range entry.deps {
+			if slices.Contains(slugs, dep) {
+				delete(c.entries, path)
+				break
+			}
+		}
+	}
+}
+
+// forgetRendered は paths (リポジトリ相対パス) のページの描画結果を捨てる。
+func (a *app) forgetRendered(paths []string) {
+	pages, _ := a.manualIndex()
+	var slugs []string
+	for slug, meta := range pages {
+		if slices.Contains(paths, meta.GitPath) {
+			slugs = append(slugs, slug)
+		}
+	}
+	a.renders.forget(slugs)
+}
+
+// reset はすべての結果を捨てる。目次が変わるとリンクの表示や {{children}} が変わるため。
+func (c *renderCache) reset() {
+	c.mu.Lock()
+	c.entries = make(map[string]renderCacheEntry)
+	c.mu.Unlock()
+}
+
+type renderCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Pages   int   `json:"pages"`
+	Version int   `json:"version"`
+}
+
+func (c *renderCache) stats() renderCacheStats {
+	c.mu.Lock()
+	pages := len(c.entries)
+	c.mu.Unlock()
+	return renderCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Pages: pages, Version: rendererVersion}
+}
+
+// handleRenderCache は GET /debug/render-cache で描画結果のキャッシュの使われ方を JSON で返す。
+func (a *app) handleRenderCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(a.renders.stats()); err != nil {
+		log.Printf("キャッシュの状態を返せませんでした: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderCacheVolatileHeading(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page.md")
+	if err := os.WriteFile(path, []byte("# 手順\n\n## {{today}} の作業\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := newRenderCache()
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+
+	page, err := c.load(path, &mdContext{slug: "page", now: day, shifts: defaultShiftClock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Volatile || !strings.Contains(string(page.Content), "2026-10-18 の作業") {
+		t.Fatalf("1日目: Volatile=%v %s", page.Volatile, page.Content)
+	}
+
+	// 日付が変わったら描画し直す
+	page, err = c.load(path, &mdContext{slug: "page", now: day.AddDate(0, 0, 1), shifts: defaultShiftClock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page.Content), "2026-10-19 の作業") {
+		t.Fatalf("2日目に前日の描画結果を返しました: %s", page.Content)
+	}
+	if stats := c.stats(); stats.Hits != 0 || stats.Misses != 2 {
+		t.Fatalf("hits=%d misses=%d; want 0, 2", stats.Hits, stats.Misses)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var errUnrecordedChanges = errors.New("file has changes that are not recorded in history")
+
+// handleRevert は POST /revert でページの内容を指定した版に戻し、新しい版として記録する。
+// 過去の履歴は書き換えない。
+func (a *app) handleRevert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "フォームの解析に失敗しました", http.StatusBadRequest)
+		return
+	}
+	if a.history == nil {
+		http.Error(w, "履歴の保存先がないため元に戻せません。", http.StatusServiceUnavailable)
+		return
+	}
+
+	slug := strings.TrimSpace(r.PostFormValue("page"))
+	if slug == "" {
+		slug = "top"
+	}
+	meta, ok := a.lookupPage(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	rev, err := a.history.Resolve(strings.TrimSpace(r.PostFormValue("commit")))
+	if err != nil {
+		http.Error(w, "指定の履歴が見つかりません", http.StatusNotFound)
+		return
+	}
+	data, err := a.history.ReadFile(rev.ID, meta.GitPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, object.ErrFileNotFound) {
+			http.Error(w, "指定の履歴にこのページは存在しません", http.StatusNotFound)
+			return
+		}
+		log.Printf("版 %s の読み込みに失敗しました: %v", rev.ID, err)
+		http.Error(w, "履歴の読み込みに失敗しました", http.StatusInternalServerError)
+		return
+	}
+
+	who := a.editorFromForm(r)
+	if who.Name == "" {
+		who = a.editorFor("マニュアル編集者")
+	} else {
+		rememberEditor(w, who)
+	}
+	message := fmt.Sprintf("「%s」を %s の版に戻す", meta.Title, rev.When.Format("2006-01-02 15:04"))
+
+	result, err := queueWrite(r.Context(), a.writes, func() (commitResult, error) {
+		// テキストエディタなどで加えた、まだ記録していない変更を上書きしない
+		if dirty, err := a.hasUnrecordedChanges(meta.GitPath); err != nil || dirty {
+			if err == nil {
+				err = errUnrecordedChanges
+			}
+			return commitResult{}, err
+		}
+		path := a.manualAbsPath(meta.RelFile)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return commitResult{}, err
+		}
+		current, err := os.ReadFile(path)
+		existed := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return commitResult{}, err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return commitResult{}, err
+		}
+		result, err := a.commitManual(who, message, false, meta.GitPath)
+		var invalid *validationError
+		if errors.As(err, &invalid) {
+			if err := restoreFile(path, current, existed); err != nil {
+				log.Printf("元に戻す前の内容に戻せませんでした: %v", err)
+			}
+		}
+		return result, err
+	})
+	if note, ok := validationNote(err); ok {
+		// 編集画面と同じく、元の画面 (差分ビュー) にエラーを表示する
+		view, ok := a.buildDiffView(w, r, slug, meta, rev.ID, "source")
+		if !ok {
+			return
+		}
+		view.Flash = &flashMessage{Type: "error", Message: note}
+		view.EditEditor = who.ID
+		view.EditAuthor = who.Name
+		a.render(w, view)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoChanges):
+			http.Redirect(w, r, makePageLink(slug), http.StatusSeeOther)
+		case errors.Is(err, errUnrecordedChanges):
+			http.Error(w, "このページのファイルに、まだ履歴に記録していない変更があるため元に戻していません。「未記録の変更」(/changes) で記録してから、もう一度お試しください。", http.StatusConflict)
+		case errors.Is(err, errWriteTimeout):
+			http.Error(w, "他の保存や同期の処理が混み合っています。しばらくしてから再度お試しください。", http.StatusServiceUnavailable)
+		default:
+			log.Printf("版を戻せませんでした: %v", err)
+			http.Error(w, "元に戻せませんでした", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Redirect(w, r, savedRedirect(makePageLink(slug), result), http.StatusSeeOther)
+}
+
+// hasUnrecordedChanges は作業コピーの gitPath が最新の版から変わっているかどうかを返す。
+func (a *app) hasUnrecordedChanges(gitPath string) (bool, error) {
+	changed, err := a.history.Changes(path.Dir(gitPath))
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(changed, gitPath), nil
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var (
+	errNoRevisions      = errors.New("no revisions yet")
+	errRevisionNotFound = errors.New("revision not found")
+)
+
+// historyStore はページの版を記録・参照する先。通常は Git を使い、
+// Git が使えない PC では revisionDir 以下のファイルに記録する。
+// パスはすべてリポジトリ (プロジェクト) ルートからの相対パス。
+type historyStore interface {
+	// Commit は paths の作業コピーの内容を author <email> の1つの版として記録する。a.writes の中から呼ぶ。
+	// coalesce が true なら、同じ編集者の直前の版にまとめてよい (編集画面からの保存だけが指定する)。
+	Commit(author, email, message string, paths []string, coalesce bool) (unrelated []string, err error)
+	// Log は path を変更した版を新しい順に最大 limit 件返す。
+	Log(path string, limit int) ([]revision, error)
+	// Resolve はハッシュ・版 ID・参照名から版を探す。
+	Resolve(rev string) (revision, error)
+	// At は指定日時までに記録された最新の版を返す。
+	At(until time.Time) (revision, error)
+	// Head は最新の版を返す。まだ版がなければ errNoRevisions を返す。
+	Head() (revision, error)
+	// ReadFile は版 id の時点の path の内容を返す。
+	ReadFile(id, path string) ([]byte, error)
+	// Changes は dir 以下で最新の版から作業コピーの内容が変わっているファイルを返す。
+	Changes(dir string) ([]string, error)
+}
+
+type revision struct {
+	ID      string
+	Author  string
+	Email   string
+	When    time.Time
+	Message string
+}
+
+func (r revision) shortID() string {
+	if len(r.ID) > 7 {
+		return r.ID[:7]
+	}
+	return r.ID
+}
+
+func revisionFromCommit(commit *object.Commit) revision {
+	return revision{
+		ID:      commit.Hash.String(),
+		Author:  commit.Author.Name,
+		Email:   commit.Author.Email,
+		When:    commit.Author.When,
+		Message: commit.Message,
+	}
+}
+
+// gitHistory は Git リポジトリを履歴の保存先として使う。
+type gitHistory struct {
+	app *app
+}
+
+func (h gitHistory) Commit(author, email, message string, paths []string, coalesce bool) ([]string, error) {
+	a := h.app
+
+	branch, err := headBranch(a.repo)
+	if err != nil {
+		return nil, err
+	}
+	head, err := headCommit(a.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		baseTree *object.Tree
+		parents  []plumbing.Hash
+		oldHash  = plumbing.ZeroHash
+	)
+	if head != nil {
+		if baseTree, err = head.Tree(); err != nil {
+			return nil, err
+		}
+		parents = []plumbing.Hash{head.Hash}
+		oldHash = head.Hash
+	}
+	baseFiles, err := treeFiles(baseTree)
+	if err != nil {
+		return nil, err
+	}
+
+	// 変更の有無はファイルごとに HEAD と作業コピーを比べて判断する
+	var changes []fileChange
+	for _, p := range paths {
+		data, err := os.ReadFile(a.repoAbsPath(p))
+		entry, tracked := baseFiles[p]
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if tracked {
+				changes = append(changes, fileChange{Path: p})
+			}
+		case err != nil:
+			return nil, err
+		case !tracked || plumbing.ComputeHash(plumbing.BlobObject, data) != entry.Hash:
+			changes = append(changes, fileChange{Path: p, Content: data})
+		}
+	}
+
+	unrelated, err := a.unrelatedChanges(paths)
+	if err != nil {
+		log.Printf("作業コピーの状態を確認できませんでした: %v", err)
+	}
+
+	if len(changes) == 0 {
+		return unrelated, errNoChanges
+	}
+
+	// 同じ編集者が続けて保存した場合は直前のコミットを作り直してまとめる
+	coalescing := coalesce && a.shouldCoalesce(head, email, paths)
+	if coalescing {
+		parents = head.ParentHashes
+		message = coalescedMessage(head.Message, message)
+	}
+
+	treeHash, err := buildTree(a.repo.Storer, baseTree, changes)
+	if err != nil {
+		return unrelated, err
+	}
+
+	// まとめた結果が直前のコミットの親と同じ内容なら (保存した変更を元に戻した)、空のコミットを作らずに取り消す
+	if coalescing && len(parents) == 1 {
+		parent, err := a.repo.CommitObject(parents[0])
+		if err != nil {
+			return unrelated, err
+		}
+		if parent.TreeHash == treeHash {
+			if err := updateBranch(a.repo, branch, oldHash, parent.Hash); err != nil {
+				return unrelated, err
+			}
+			a.lastEdit = plumbing.ZeroHash
+			return unrelated, a.stagePaths(changesPaths(changes))
+		}
+	}
+
+	committer := object.Signature{
+		Name:  author,
+		Email: email,
+		When:  time.Now(),
+	}
+	// まとめるときは作成日時を最初の保存のままにする。まとめる時間はここから測るので、
+	// 保存し続けても a.coalesce を超えたら新しい版になる
+	signature := committer
+	if coalescing {
+		signature.When = head.Author.When
+	}
+	hash, err := createCommit(a.repo.Storer, treeHash, parents, signature, committer, message)
+	if err != nil {
+		return unrelated, err
+	}
+	if err := updateBranch(a.repo, branch, oldHash, hash); err != nil {
+		return unrelated, err
+	}
+	a.lastEdit = plumbing.ZeroHash
+	if coalesce {
+		a.lastEdit = hash
+	}
+	if err := a.stagePaths(changesPaths(changes)); err != nil {
+		return unrelated, err
+	}
+	return unrelated, nil
+}
+
+func (h gitHistory) Log(path string, limit int) ([]revision, error) {
+	iter, err := h.app.repo.Log(&git.LogOptions{FileName: stringPtr(path)})
+	if err != nil {
+		if errors.Is(err, plumbing.ErrObjectNotFound) || errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer iter.Close()
+
+	var revisions []revision
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if len(revisions) >= limit {
+			return storer.ErrStop
+		}
+		revisions = append(revisions, revisionFromCommit(commit))
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return revisions, err
+	}
+	return revisions, nil
+}
+
+func (h gitHistory) Resolve(rev string) (revision, error) {
+	commit, err := h.app.resolveCommit(rev)
+	if err != nil {
+		return revision{}, err
+	}
+	return revisionFromCommit(commit), nil
+}
+
+func (h gitHistory) At(until time.Time) (revision, error) {
+	iter, err := h.app.repo.Log(&git.LogOptions{Order: git.LogOrderCommitterTime, Until: &until})
+	if err != nil {
+		return revision{}, err
+	}
+	defer iter.Close()
+
+	commit, err := iter.Next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return revision{}, errRevisionNotFound
+		}
+		return revision{}, err
+	}
+	return revisionFromCommit(commit), nil
+}
+
+func (h gitHistory) Head() (revision, error) {
+	commit, err := headCommit(h.app.repo)
+	if err != nil {
+		return revision{}, err
+	}
+	if commit == nil {
+		return revision{}, errNoRevisions
+	}
+	return revisionFromCommit(commit), nil
+}
+
+func (h gitHistory) ReadFile(id, path string) ([]byte, error) {
+	commit, err := h.app.repo.CommitObject(plumbing.NewHash(id))
+	if err != nil {
+		return nil, err
+	}
+	return readCommitFile(commit, path)
+}
+
+func (h gitHistory) Changes(dir string) ([]string, error) {
+	a := h.app
+	worktree, err := a.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	head, err := headCommit(a.repo)
+	if err != nil {
+		return nil, err
+	}
+	var headTree *object.Tree
+	if head != nil {
+		if headTree, err = head.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	headFiles, err := treeFiles(headTree)
+	if err != nil {
+		return nil, err
+	}
+
+	// ステージしただけで作業コピーが HEAD と同じファイルは記録するものがないので除く
+	var changed []string
+	for p, fs := range status {
+		if !inDir(p, dir) || (fs.Staging == git.Unmodified && fs.Worktree == git.Unmodified) {
+			continue
+		}
+		data, err := os.ReadFile(a.repoAbsPath(p))
+		entry, tracked := headFiles[p]
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if !tracked {
+				continue
+			}
+		case err != nil:
+			return nil, err
+		case tracked && plumbing.ComputeHash(plumbing.BlobObject, data) == entry.Hash:
+			continue
+		}
+		changed = append(changed, p)
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// inDir は path が dir 以下にあるかを返す。dir が空ならすべてのパスが対象。
+func inDir(path, dir string) bool {
+	return dir == "" || dir == "." || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// revisionDir は Git が使えない場合に版を記録するディレクトリ (プロジェクトルートからの相対パス)。
+const revisionDir = ".lfwiki/revisions"
+
+// fileHistory は版をファイルに記録する。log.jsonl に1行1版でメタ情報と変更した
+// ファイルを追記し、ファイルの内容は objects/ 以下に SHA-1 ごとに保存する。
+type fileHistory struct {
+	root string
+	dir  string
+	mu   sync.Mutex
+}
+
+type fileRevision struct {
+	ID      string    `json:"id"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	When    time.Time `json:"when"`
+	Message string    `json:"message"`
+	// Files は変更したファイルのパスと内容の SHA-1。削除した場合は空文字。
+	Files map[string]string `json:"files"`
+}
+
+func (r fileRevision) revision() revision {
+	return revision{ID: r.ID, Author: r.Author, Email: r.Email, When: r.When, Message: r.Message}
+}
+
+func newFileHistory(projectRoot string) (*fileHistory, error) {
+	h := &fileHistory{root: projectRoot, dir: filepath.Join(projectRoot, filepath.FromSlash(revisionDir))}
+	if err := os.MkdirAll(filepath.Join(h.dir, "objects"), 0o755); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *fileHistory) load() ([]fileRevision, error) {
+	f, err := os.Open(filepath.Join(h.dir, "log.jsonl"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var revisions []fileRevision
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rev fileRevision
+		if err := json.Unmarshal(line, &rev); err != nil {
+			return nil, fmt.Errorf("版の記録が壊れています: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, scanner.Err()
+}
+
+func (h *fileHistory) objectPath(sum string) string {
+	return filepath.Join(h.dir, "objects", sum[:2], sum[2:])
+}
+
+func (h *fileHistory) readObject(sum string) ([]byte, error) {
+	return os.ReadFile(h.objectPath(sum))
+}
+
+func (h *fileHistory) writeObject(data []byte) (string, error) {
+	raw := sha1.Sum(data)
+	sum := hex.EncodeToString(raw[:])
+	p := h.objectPath(sum)
+	if _, err := os.Stat(p); err == nil {
+		return sum, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	return sum, os.Rename(tmp, p)
+}
+
+// lastSum は revisions の中で path が最後に記録された内容の SHA-1 を返す。
+func lastSum(revisions []fileRevision, path string) (string, bool) {
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if sum, ok := revisions[i].Files[path]; ok {
+			return sum, true
+		}
+	}
+	return "", false
+}
+
+// Commit は版をまとめない (coalesce は Git を使う場合だけ)。
+func (h *fileHistory) Commit(author, email, message string, paths []string, _ bool) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	revisions, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	for _, p := range paths {
+		prev, recorded := lastSum(revisions, p)
+		data, err := os.ReadFile(filepath.Join(h.root, filepath.FromSlash(p)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if recorded && prev != "" {
+				files[p] = ""
+			}
+		case err != nil:
+			return nil, err
+		default:
+			sum, err := h.writeObject(data)
+			if err != nil {
+				return nil, err
+			}
+			if !recorded || sum != prev {
+				files[p] = sum
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, errNoChanges
+	}
+
+	rev := fileRevision{
+		ID:      fmt.Sprintf("r%06d", len(revisions)+1),
+		Author:  author,
+		Email:   email,
+		When:    time.Now(),
+		Message: message,
+		Files:   files,
+	}
+	line, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(h.dir, "log.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return nil, f.Close()
+}
+
+func (h *fileHistory) Log(path string, limit int) ([]revision, error) {
+	h.mu.Lock()
+	revisions, err := h.load()
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []revision
+	for i := len(revisions) - 1; i >= 0 && len(result) < limit; i-- {
+		if _, ok := revisions[i].Files[path]; ok {
+			result = append(result, revisions[i].revision())
+		}
+	}
+	return result, nil
+}
+
+func (h *fileHistory) Resolve(rev string) (revision, error) {
+	h.mu.Lock()
+	revisions, err := h.load()
+	h.mu.Unlock()
+	if err != nil {
+		return revision{}, err
+	}
+	if len(revisions) > 0 && rev == "HEAD" {
+		return revisions[len(revisions)-1].revision(), nil
+	}
+	for _, r := range revisions {
+		if r.ID == rev {
+			return r.revision(), nil
+		}
+	}
+	return revision{}, errRevisionNotFound
+}
+
+func (h *fileHistory) At(until time.Time) (revision, error) {
+	h.mu.Lock()
+	revisions, err := h.load()
+	h.mu.Unlock()
+	if err != nil {
+		return revision{}, err
+	}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if !revisions[i].When.After(until) {
+			return revisions[i].revision(), nil
+		}
+	}
+	return revision{}, errRevisionNotFound
+}
+
+func (h *fileHistory) Head() (revision, error) {
+	h.mu.Lock()
+	revisions, err := h.load()
+	h.mu.Unlock()
+	if err != nil {
+		return revision{}, err
+	}
+	if len(revisions) == 0 {
+		return revision{}, errNoRevisions
+	}
+	return revisions[len(revisions)-1].revision(), nil
+}
+
+func (h *fileHistory) ReadFile(id, path string) ([]byte, error) {
+	h.mu.Lock()
+	revisions, err := h.load()
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	end := -1
+	for i, r := range revisions {
+		if r.ID == id {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, errRevisionNotFound
+	}
+	sum, ok := lastSum(revisions[:end+1], path)
+	if !ok || sum == "" {
+		return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	return h.readObject(sum)
+}
+
+func (h *fileHistory) Changes(dir string) ([]string, error) {
+	h.mu.Lock()
+	revisions, err := h.load()
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var changed []string
+	err = filepath.WalkDir(filepath.Join(h.root, filepath.FromSlash(dir)), func(abs string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && abs != h.root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(h.root, abs)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		seen[p] = true
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		raw := sha1.Sum(data)
+		if sum, ok := lastSum(revisions, p); !ok || sum != hex.EncodeToString(raw[:]) {
+			changed = append(changed, p)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// 記録済みで作業コピーから消えたファイル
+	deleted := make(map[string]bool)
+	for _, r := range revisions {
+		for p := range r.Files {
+			if !seen[p] && inDir(p, dir) {
+				deleted[p] = true
+			}
+		}
+	}
+	for p := range deleted {
+		if sum, _ := lastSum(revisions, p); sum != "" {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// importRevisions はファイルに記録した版を順番に Git のコミットとして現在のブランチに
+// 積み、取り込み済みの記録を revisionDir とは別の名前に退避する。
+func importRevisions(projectRoot string) error {
+	h, err := newFileHistory(projectRoot)
+	if err != nil {
+		return err
+	}
+	revisions, err := h.load()
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		log.Printf("取り込む版はありません (%s)", revisionDir)
+		return nil
+	}
+
+	repo, err := git.PlainOpenWithOptions(projectRoot, &git.PlainOpenOptions{DetectDotGit: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInit(projectRoot, false)
+	}
+	if err != nil {
+		return fmt.Errorf("Git リポジトリを開けません: %w", err)
+	}
+
+	branch, err := headBranch(repo)
+	if err != nil {
+		return err
+	}
+	head, err := headCommit(repo)
+	if err != nil {
+		return err
+	}
+
+	touched := make(map[string]bool)
+	for _, rev := range revisions {
+		var (
+			baseTree *object.Tree
+			parents  []plumbing.Hash
+			oldHash  = plumbing.ZeroHash
+		)
+		if head != nil {
+			if baseTree, err = head.Tree(); err != nil {
+				return err
+			}
+			parents = []plumbing.Hash{head.Hash}
+			oldHash = head.Hash
+		}
+
+		changes := make([]fileChange, 0, len(rev.Files))
+		for p, sum := range rev.Files {
+			change := fileChange{Path: p}
+			if sum != "" {
+				if change.Content, err = h.readObject(sum); err != nil {
+					return fmt.Errorf("版 %s の %s を読み込めません: %w", rev.ID, p, err)
+				}
+			}
+			changes = append(changes, change)
+			touched[p] = true
+		}
+
+		treeHash, err := buildTree(repo.Storer, baseTree, changes)
+		if err != nil {
+			return err
+		}
+		signature := object.Signature{Name: rev.Author, Email: rev.Email, When: rev.When}
+		hash, err := createCommit(repo.Storer, treeHash, parents, signature, signature, rev.Message)
+		if err != nil {
+			return err
+		}
+		if err := updateBranch(repo, branch, oldHash, hash); err != nil {
+			return err
+		}
+		if head, err = repo.CommitObject(hash); err != nil {
+			return err
+		}
+		log.Printf("版 %s を %s として取り込みました", rev.ID, hash.String()[:7])
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(touched))
+	for p := range touched {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		err := worktree.AddWithOptions(&git.AddOptions{Path: p, SkipStatus: true})
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			log.Printf("%s をインデックスに反映できませんでした: %v", p, err)
+		}
+	}
+
+	imported := h.dir + ".imported-" + time.Now().Format("20060102-150405")
+	if err := os.Rename(h.dir, imported); err != nil {
+		return err
+	}
+	log.Printf("%d 件の版を取り込みました。元の記録は %s に残しています", len(revisions), imported)
+	return nil
+}
+
+// pendingRevisions はファイルに記録され、まだ Git に取り込まれていない版の数を返す。
+func pendingRevisions(projectRoot string) int {
+	data, err := os.ReadFile(filepath.Join(projectRoot, filepath.FromSlash(revisionDir), "log.jsonl"))
+	if err != nil {
+		return 0
+	}
+	return bytes.Count(data, []byte("\n"))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// shiftClock は日勤と夜勤が始まる時刻 (0時からの経過時間)。
+// 夜勤の開始から翌日の日勤の開始までは、夜勤が始まった日のシフトとして扱う。
+type shiftClock struct {
+	dayStart   time.Duration
+	nightStart time.Duration
+}
+
+var defaultShiftClock = shiftClock{dayStart: 9 * time.Hour, nightStart: 19 * time.Hour}
+
+// shift は勤務のシフト。Date はシフトが始まった日。
+type shift struct {
+	Date time.Time
+	Kind string // "day" または "night"
+}
+
+const (
+	shiftDay   = "day"
+	shiftNight = "night"
+)
+
+// at は t の時点のシフトを返す。
+func (c shiftClock) at(t time.Time) shift {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	elapsed := t.Sub(midnight)
+	switch {
+	case elapsed < c.dayStart:
+		return shift{Date: midnight.AddDate(0, 0, -1), Kind: shiftNight}
+	case elapsed < c.nightStart:
+		return shift{Date: midnight, Kind: shiftDay}
+	default:
+		return shift{Date: midnight, Kind: shiftNight}
+	}
+}
+
+// Key はシフトを保存するときの名前 (2025-11-01-day)。
+func (s shift) Key() string {
+	return s.Date.Format("2006-01-02") + "-" + s.Kind
+}
+
+// Name は「日勤」「夜勤」。
+func (s shift) Name() string {
+	if s.Kind == shiftNight {
+		return "夜勤"
+	}
+	return "日勤"
+}
+
+func (s shift) Label() string {
+	return s.Date.Format("2006-01-02") + " " + s.Name()
+}
+
+// parseShiftKey は Key の形式の文字列からシフトを読み取る。
+func parseShiftKey(key string) (shift, bool) {
+	key = strings.TrimSpace(key)
+	kind := shiftDay
+	date, ok := strings.CutSuffix(key, "-"+shiftDay)
+	if !ok {
+		kind = shiftNight
+		if date, ok = strings.CutSuffix(key, "-"+shiftNight); !ok {
+			return shift{}, false
+		}
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return shift{}, false
+	}
+	return shift{Date: d, Kind: kind}, true
+}
+
+// parseShiftClock は -day-shift と -night-shift の時刻 (15:04) を読み取る。
+func parseShiftClock(day, night string) (shiftClock, error) {
+	parse := func(v string) (time.Duration, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("時刻は 09:00 の形式で指定してください: %s", v)
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+	d, err := parse(day)
+	if err != nil {
+		return shiftClock{}, err
+	}
+	n, err := parse(night)
+	if err != nil {
+		return shiftClock{}, err
+	}
+	if d >= n {
+		return shiftClock{}, fmt.Errorf("夜勤の開始 (%s) は日勤の開始 (%s) より後にしてください", night, day)
+	}
+	return shiftClock{dayStart: d, nightStart: n}, nil
+}
@@ -0,0 +1,801 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const syncRemoteName = "manual-sync"
+
+var (
+	errMergeConflict = errors.New("merge conflict")
+	errSyncStale     = errors.New("sync state changed")
+)
+
+// resolutionInputError は競合の解決で選んだ内容が正しくないことを表す。
+// 入力の誤りなので、同期の状態は変えずに画面でだけ知らせる。
+type resolutionInputError struct {
+	msg string
+}
+
+func (e *resolutionInputError) Error() string {
+	return e.msg
+}
+
+// manualSync は設定されたリモートとマニュアルのリポジトリを定期的に同期する。
+// 取り込みは早送りを優先し、分岐している場合はマージコミットを作る。
+// 自動でマージできないファイルは競合として保留し、/sync で解決してもらう。
+type manualSync struct {
+	app      *app
+	url      string
+	interval time.Duration
+	push     bool
+	wake     chan struct{}
+
+	mu      sync.Mutex
+	state   syncStatus
+	pending *pendingMerge
+}
+
+type syncStatus struct {
+	State    string
+	Label    string
+	Detail   string
+	LastSync string
+}
+
+type pendingMerge struct {
+	local     plumbing.Hash
+	remote    plumbing.Hash
+	merged    []fileChange
+	conflicts []mergeConflict
+}
+
+// mergeConflict は両方で変更され自動で統合できなかったファイル。nil は存在しないことを表す。
+type mergeConflict struct {
+	Path   string
+	Base   []byte
+	Local  []byte
+	Remote []byte
+}
+
+type syncConflictView struct {
+	Index         int
+	Path          string
+	LocalText     string
+	RemoteText    string
+	LocalMissing  bool
+	RemoteMissing bool
+	Binary        bool
+	DiffHTML      template.HTML
+}
+
+func newManualSync(a *app, url string, interval time.Duration, push bool) *manualSync {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &manualSync{
+		app:      a,
+		url:      url,
+		interval: interval,
+		push:     push,
+		wake:     make(chan struct{}, 1),
+		state:    syncStatus{State: "pending", Label: "同期待ち"},
+	}
+}
+
+func (s *manualSync) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.syncOnce()
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// trigger は次の同期をすぐに実行させる。
+func (s *manualSync) trigger() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *manualSync) status() syncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *manualSync) syncOnce() {
+	err := s.sync()
+	s.recordResult(err)
+}
+
+func (s *manualSync) recordResult(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := syncStatus{LastSync: time.Now().Format("2006-01-02 15:04:05")}
+	var dirty *dirtyFilesError
+	switch {
+	case err == nil:
+		state.State, state.Label = "ok", "同期済み"
+	case errors.Is(err, errMergeConflict):
+		state.State, state.Label = "conflict", "競合あり"
+		state.Detail = "他の PC と同じ箇所が編集されています。同期画面で内容を選んでください。"
+	case errors.As(err, &dirty):
+		state.State, state.Label = "blocked", "取り込み保留"
+		state.Detail = "未コミットの変更があるため、リモートの変更を取り込めません: " + strings.Join(dirty.Paths, ", ")
+	default:
+		log.Printf("リモートとの同期に失敗しました: %v", err)
+		state.State, state.Label = "error", "同期エラー"
+		state.Detail = err.Error()
+	}
+	s.state = state
+}
+
+func (s *manualSync) sync() error {
+	a := s.app
+
+	if err := s.ensureRemote(); err != nil {
+		return err
+	}
+
+	emptyRemote := false
+	err := a.repo.Fetch(&git.FetchOptions{RemoteName: syncRemoteName})
+	switch {
+	case errors.Is(err, transport.ErrEmptyRemoteRepository):
+		emptyRemote = true
+	case err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate):
+		return fmt.Errorf("リモートから取得できません: %w", err)
+	}
+
+	// 取得はリモート追跡ブランチしか書き換えないので、ネットワークを待つ間は
+	// 保存を止めないよう書き込みキューの外で行う。送信も同様。
+	integrated, err := queueWrite(context.Background(), a.writes, func() (syncIntegration, error) {
+		branch, push, err := s.integrate(emptyRemote)
+		return syncIntegration{branch: branch, push: push}, err
+	})
+	if err != nil {
+		return err
+	}
+	if integrated.push {
+		return s.pushBranch(integrated.branch)
+	}
+	return nil
+}
+
+// syncIntegration は書き込みキューで取り込んだ結果。
+type syncIntegration struct {
+	branch plumbing.ReferenceName
+	push   bool
+}
+
+// integrate はリモートの変更をローカルのブランチに取り込む。書き込みキューの中で呼ぶ。
+// 戻り値の push はリモートへ送信すべきローカルの変更があるかどうか。
+func (s *manualSync) integrate(emptyRemote bool) (plumbing.ReferenceName, bool, error) {
+	a := s.app
+
+	branch, err := headBranch(a.repo)
+	if err != nil {
+		return "", false, err
+	}
+	local, err := headCommit(a.repo)
+	if err != nil {
+		return "", false, err
+	}
+
+	var remoteRef *plumbing.Reference
+	if !emptyRemote {
+		remoteRef, err = a.repo.Reference(plumbing.NewRemoteReferenceName(syncRemoteName, branch.Short()), true)
+		if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return "", false, err
+		}
+	}
+	if remoteRef == nil {
+		return branch, local != nil, nil
+	}
+
+	remote, err := a.repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return "", false, err
+	}
+
+	if local == nil {
+		return branch, false, s.fastForward(branch, nil, remote)
+	}
+	if local.Hash == remote.Hash {
+		s.clearPending()
+		return branch, false, nil
+	}
+	if ok, err := remote.IsAncestor(local); err != nil {
+		return "", false, err
+	} else if ok {
+		s.clearPending()
+		return branch, true, nil
+	}
+	if ok, err := local.IsAncestor(remote); err != nil {
+		return "", false, err
+	} else if ok {
+		s.clearPending()
+		return branch, false, s.fastForward(branch, local, remote)
+	}
+	if err := s.merge(branch, local, remote); err != nil {
+		return "", false, err
+	}
+	return branch, true, nil
+}
+
+func (s *manualSync) ensureRemote() error {
+	repo := s.app.repo
+	remote, err := repo.Remote(syncRemoteName)
+	switch {
+	case err == nil:
+		urls := remote.Config().URLs
+		if len(urls) == 1 && urls[0] == s.url {
+			return nil
+		}
+		if err := repo.DeleteRemote(syncRemoteName); err != nil {
+			return err
+		}
+	case !errors.Is(err, git.ErrRemoteNotFound):
+		return err
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: syncRemoteName, URLs: []string{s.url}})
+	return err
+}
+
+func (s *manualSync) pushBranch(branch plumbing.ReferenceName) error {
+	if !s.push {
+		return nil
+	}
+	spec := config.RefSpec(branch.String() + ":" + branch.String())
+	err := s.app.repo.Push(&git.PushOptions{RemoteName: syncRemoteName, RefSpecs: []config.RefSpec{spec}})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("リモートへ送信できません: %w", err)
+	}
+	// タグを送れなくてもブランチの同期は済んでいるので、記録だけ残して続ける
+	if err := s.pushReleaseTags(); err != nil {
+		log.Printf("リリースのタグを送信できません: %v", err)
+	}
+	return nil
+}
+
+// pushReleaseTags は /releases で作ったリリースのタグ (注釈付きタグ) のうち、
+// リモートにまだないものだけを送信する。同じ名前で別の版を指すタグがリモートにあれば送らない。
+func (s *manualSync) pushReleaseTags() error {
+	a := s.app
+	remote, err := a.repo.Remote(syncRemoteName)
+	if err != nil {
+		return err
+	}
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return err
+	}
+	remoteTags := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for _, ref := range refs {
+		if ref.Name().IsTag() {
+			remoteTags[ref.Name()] = ref.Hash()
+		}
+	}
+
+	iter, err := a.repo.Tags()
+	if err != nil {
+		return err
+	}
+	var specs []config.RefSpec
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if !releaseNamePattern.MatchString(ref.Name().Short()) {
+			return nil
+		}
+		if _, err := a.repo.TagObject(ref.Hash()); err != nil {
+			// 軽量タグはこのアプリで作ったものではないので送らない
+			return nil
+		}
+		if hash, ok := remoteTags[ref.Name()]; ok {
+			if hash != ref.Hash() {
+				log.Printf("リモートに同じ名前の別のタグがあるため %s は送信しません", ref.Name().Short())
+			}
+			return nil
+		}
+		specs = append(specs, config.RefSpec(ref.Name().String()+":"+ref.Name().String()))
+		return nil
+	})
+	if err != nil || len(specs) == 0 {
+		return err
+	}
+	err = a.repo.Push(&git.PushOptions{RemoteName: syncRemoteName, RefSpecs: specs})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
+
+// fastForward はローカルのブランチをリモートのコミットまで進め、作業コピーを更新する。
+func (s *manualSync) fastForward(branch plumbing.ReferenceName, local, remote *object.Commit) error {
+	a := s.app
+
+	var localTree *object.Tree
+	oldHash := plumbing.ZeroHash
+	if local != nil {
+		tree, err := local.Tree()
+		if err != nil {
+			return err
+		}
+		localTree = tree
+		oldHash = local.Hash
+	}
+	remoteTree, err := remote.Tree()
+	if err != nil {
+		return err
+	}
+
+	changes, err := treeChanges(a.repo, localTree, remoteTree)
+	if err != nil {
+		return err
+	}
+	return s.moveBranch(branch, oldHash, remote.Hash, localTree, changes)
+}
+
+// merge は分岐したローカルとリモートを統合する。競合がなければマージコミットを作り、
+// 競合があれば pending に記録して errMergeConflict を返す。
+func (s *manualSync) merge(branch plumbing.ReferenceName, local, remote *object.Commit) error {
+	a := s.app
+
+	s.mu.Lock()
+	pending := s.pending
+	s.mu.Unlock()
+	if pending != nil && pending.local == local.Hash && pending.remote == remote.Hash {
+		return errMergeConflict
+	}
+
+	var baseTree *object.Tree
+	bases, err := local.MergeBase(remote)
+	if err != nil {
+		return err
+	}
+	if len(bases) > 0 {
+		if baseTree, err = bases[0].Tree(); err != nil {
+			return err
+		}
+	}
+	localTree, err := local.Tree()
+	if err != nil {
+		return err
+	}
+	remoteTree, err := remote.Tree()
+	if err != nil {
+		return err
+	}
+
+	baseFiles, err := treeFiles(baseTree)
+	if err != nil {
+		return err
+	}
+	localFiles, err := treeFiles(localTree)
+	if err != nil {
+		return err
+	}
+	remoteFiles, err := treeFiles(remoteTree)
+	if err != nil {
+		return err
+	}
+
+	read := func(files map[string]object.TreeEntry, p string) ([]byte, error) {
+		entry, ok := files[p]
+		if !ok {
+			return nil, nil
+		}
+		return readBlob(a.repo, entry.Hash)
+	}
+
+	paths := make(map[string]bool)
+	for p, entry := range remoteFiles {
+		if old, ok := baseFiles[p]; !ok || old.Hash != entry.Hash {
+			paths[p] = true
+		}
+	}
+	for p := range baseFiles {
+		if _, ok := remoteFiles[p]; !ok {
+			paths[p] = true
+		}
+	}
+
+	var (
+		merged    []fileChange
+		conflicts []mergeConflict
+	)
+	for _, p := range slices.Sorted(maps.Keys(paths)) {
+		if sameTreeEntry(localFiles, remoteFiles, p) {
+			continue
+		}
+		remoteData, err := read(remoteFiles, p)
+		if err != nil {
+			return err
+		}
+		if sameTreeEntry(baseFiles, localFiles, p) {
+			merged = append(merged, fileChange{Path: p, Content: remoteData})
+			continue
+		}
+
+		baseData, err := read(baseFiles, p)
+		if err != nil {
+			return err
+		}
+		localData, err := read(localFiles, p)
+		if err != nil {
+			return err
+		}
+		if baseData != nil && localData != nil && remoteData != nil {
+			if out, ok := mergeLines(baseData, localData, remoteData); ok {
+				merged = append(merged, fileChange{Path: p, Content: out})
+				continue
+			}
+		}
+		conflicts = append(conflicts, mergeConflict{Path: p, Base: baseData, Local: localData, Remote: remoteData})
+	}
+
+	if len(conflicts) > 0 {
+		s.mu.Lock()
+		s.pending = &pendingMerge{local: local.Hash, remote: remote.Hash, merged: merged, conflicts: conflicts}
+		s.mu.Unlock()
+		return errMergeConflict
+	}
+	return s.completeMerge(branch, local, remote, merged)
+}
+
+func (s *manualSync) completeMerge(branch plumbing.ReferenceName, local, remote *object.Commit, changes []fileChange) error {
+	a := s.app
+
+	localTree, err := local.Tree()
+	if err != nil {
+		return err
+	}
+	treeHash, err := buildTree(a.repo.Storer, localTree, changes)
+	if err != nil {
+		return err
+	}
+
+	signature := object.Signature{Name: "マニュアル同期", Email: "sync@manual.local", When: time.Now()}
+	hash, err := createCommit(a.repo.Storer, treeHash, []plumbing.Hash{local.Hash, remote.Hash}, signature, signature, "他の PC の変更を取り込み")
+	if err != nil {
+		return err
+	}
+
+	if err := s.moveBranch(branch, local.Hash, hash, localTree, changes); err != nil {
+		return err
+	}
+	s.clearPending()
+	return nil
+}
+
+// moveBranch は作業コピーに未コミットの変更がないことを確かめてからブランチを進め、
+// changes を作業コピーとインデックスに反映する。
+func (s *manualSync) moveBranch(branch plumbing.ReferenceName, oldHash, newHash plumbing.Hash, before *object.Tree, changes []fileChange) error {
+	a := s.app
+
+	beforeFiles, err := treeFiles(before)
+	if err != nil {
+		return err
+	}
+	if err := a.checkWorktreeClean(beforeFiles, changes); err != nil {
+		return err
+	}
+	if err := updateBranch(a.repo, branch, oldHash, newHash); err != nil {
+		return err
+	}
+	if err := a.writeWorktree(changes); err != nil {
+		return err
+	}
+	if err := a.reloadIndex(); err != nil {
+		log.Printf("同期後の index.yaml の読み込みに失敗しました: %v", err)
+	}
+	return nil
+}
+
+func (s *manualSync) clearPending() {
+	s.mu.Lock()
+	s.pending = nil
+	s.mu.Unlock()
+}
+
+func (s *manualSync) conflictViews() []syncConflictView {
+	s.mu.Lock()
+	pending := s.pending
+	s.mu.Unlock()
+	if pending == nil {
+		return nil
+	}
+
+	views := make([]syncConflictView, len(pending.conflicts))
+	for i, c := range pending.conflicts {
+		view := syncConflictView{
+			Index:         i,
+			Path:          c.Path,
+			LocalText:     string(c.Local),
+			RemoteText:    string(c.Remote),
+			LocalMissing:  c.Local == nil,
+			RemoteMissing: c.Remote == nil,
+			Binary:        !utf8.Valid(c.Local) || !utf8.Valid(c.Remote),
+		}
+		if !view.Binary {
+			view.DiffHTML, _ = renderDiff(c.Local, c.Remote)
+		}
+		views[i] = view
+	}
+	return views
+}
+
+// resolutionChoice は競合したファイルごとに選ばれた解決方法。
+type resolutionChoice struct {
+	Use     string
+	Content string
+}
+
+// resolve は競合の解決内容でマージを完了させ、リモートへ送信する。
+func (s *manualSync) resolve(ctx context.Context, choices map[int]resolutionChoice) error {
+	branch, err := queueWrite(ctx, s.app.writes, func() (plumbing.ReferenceName, error) {
+		return s.completeResolution(choices)
+	})
+	if err != nil {
+		return err
+	}
+	return s.pushBranch(branch)
+}
+
+func (s *manualSync) completeResolution(choices map[int]resolutionChoice) (plumbing.ReferenceName, error) {
+	a := s.app
+
+	s.mu.Lock()
+	pending := s.pending
+	s.mu.Unlock()
+	if pending == nil {
+		return "", errSyncStale
+	}
+
+	branch, err := headBranch(a.repo)
+	if err != nil {
+		return "", err
+	}
+	local, err := headCommit(a.repo)
+	if err != nil {
+		return "", err
+	}
+	if local == nil || local.Hash != pending.local {
+		s.clearPending()
+		return "", errSyncStale
+	}
+	remote, err := a.repo.CommitObject(pending.remote)
+	if err != nil {
+		return "", err
+	}
+
+	changes := append([]fileChange(nil), pending.merged...)
+	for i, c := range pending.conflicts {
+		choice, ok := choices[i]
+		if !ok {
+			return "", &resolutionInputError{msg: c.Path + " の解決方法が選ばれていません"}
+		}
+		switch choice.Use {
+		case "local":
+		case "remote":
+			changes = append(changes, fileChange{Path: c.Path, Content: c.Remote})
+		case "edit":
+			// 空の内容は削除と区別がつかないため受け付けない。削除するときは "delete" を選ぶ
+			content := strings.ReplaceAll(choice.Content, "\r\n", "\n")
+			if strings.TrimSpace(content) == "" {
+				return "", &resolutionInputError{msg: c.Path + " の編集した内容が空です。ファイルを削除する場合は「削除する」を選んでください"}
+			}
+			changes = append(changes, fileChange{Path: c.Path, Content: []byte(content)})
+		case "delete":
+			changes = append(changes, fileChange{Path: c.Path})
+		default:
+			return "", &resolutionInputError{msg: c.Path + " の解決方法が正しくありません"}
+		}
+	}
+
+	return branch, s.completeMerge(branch, local, remote, changes)
+}
+
+func (a *app) handleSync(w http.ResponseWriter, r *http.Request) {
+	view := pageView{
+		Mode:      "sync",
+		SiteTitle: siteTitle,
+		PageTitle: "リモートとの同期",
+		TOC:       a.currentTOC(),
+	}
+
+	if a.sync == nil {
+		view.Flash = &flashMessage{Type: "info", Message: "リモートとの同期は設定されていません。起動時に -remote を指定してください。"}
+		a.render(w, view)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "フォームの解析に失敗しました", http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("action") == "resolve" {
+			choices := make(map[int]resolutionChoice)
+			for key, values := range r.PostForm {
+				idx, ok := strings.CutPrefix(key, "use-")
+				if !ok || len(values) == 0 {
+					continue
+				}
+				i, err := strconv.Atoi(idx)
+				if err != nil {
+					continue
+				}
+				choices[i] = resolutionChoice{Use: values[0], Content: r.PostFormValue("content-" + idx)}
+			}
+
+			err := a.sync.resolve(r.Context(), choices)
+			// 入力の誤りや順番待ちのタイムアウトは同期の失敗ではないので、同期の状態には残さない
+			var input *resolutionInputError
+			if !errors.As(err, &input) && !errors.Is(err, errSyncStale) && !errors.Is(err, errWriteTimeout) {
+				a.sync.recordResult(err)
+			}
+			if err != nil {
+				msg := "競合の解決に失敗しました: " + err.Error()
+				switch {
+				case input != nil:
+					msg = err.Error()
+				case errors.Is(err, errSyncStale):
+					msg = "解決中に履歴が更新されたため、もう一度同期してから解決してください。"
+					a.sync.trigger()
+				}
+				view.Flash = &flashMessage{Type: "error", Message: msg}
+				view.SyncConflicts = a.sync.conflictViews()
+				a.render(w, view)
+				return
+			}
+			http.Redirect(w, r, "/sync?resolved=1", http.StatusSeeOther)
+			return
+		}
+		a.sync.trigger()
+		http.Redirect(w, r, "/sync?requested=1", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case r.URL.Query().Get("resolved") == "1":
+		view.Flash = &flashMessage{Type: "success", Message: "競合を解決し、変更を取り込みました。"}
+	case r.URL.Query().Get("requested") == "1":
+		view.Flash = &flashMessage{Type: "info", Message: "同期を開始しました。しばらくしてから再読み込みしてください。"}
+	}
+	view.SyncConflicts = a.sync.conflictViews()
+	a.render(w, view)
+}
+
+// mergeLines は base から local / remote への変更を行単位で統合する。
+// 両方の変更が重なる (または隣接する) 場合は false を返す。
+func mergeLines(base, local, remote []byte) ([]byte, bool) {
+	if bytes.Equal(local, remote) {
+		return local, true
+	}
+	if !utf8.Valid(base) || !utf8.Valid(local) || !utf8.Valid(remote) {
+		return nil, false
+	}
+
+	baseLines := splitLines(string(base))
+	hunks := append(lineHunks(string(base), string(local)), lineHunks(string(base), string(remote))...)
+	sort.SliceStable(hunks, func(i, j int) bool { return hunks[i].start < hunks[j].start })
+
+	var out strings.Builder
+	pos := 0
+	var prev *lineHunk
+	for i := range hunks {
+		h := &hunks[i]
+		if prev != nil && h.start <= prev.end {
+			if h.start == prev.start && h.end == prev.end && slices.Equal(h.lines, prev.lines) {
+				continue
+			}
+			return nil, false
+		}
+		for ; pos < h.start; pos++ {
+			out.WriteString(baseLines[pos])
+		}
+		for _, line := range h.lines {
+			out.WriteString(line)
+		}
+		pos = h.end
+		prev = h
+	}
+	for ; pos < len(baseLines); pos++ {
+		out.WriteString(baseLines[pos])
+	}
+	return []byte(out.String()), true
+}
+
+// lineHunk は base の [start, end) 行を lines に置き換える変更。
+type lineHunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+func lineHunks(base, other string) []lineHunk {
+	dmp := diffmatchpatch.New()
+	baseRunes, otherRunes, lineArray := dmp.DiffLinesToRunes(base, other)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMainRunes(baseRunes, otherRunes, false), lineArray)
+
+	var (
+		hunks   []lineHunk
+		current *lineHunk
+		pos     int
+	)
+	for _, d := range diffs {
+		lines := splitLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			pos += len(lines)
+		case diffmatchpatch.DiffDelete:
+			if current == nil {
+				current = &lineHunk{start: pos, end: pos}
+			}
+			pos += len(lines)
+			current.end = pos
+		case diffmatchpatch.DiffInsert:
+			if current == nil {
+				current = &lineHunk{start: pos, end: pos}
+			}
+			current.lines = append(current.lines, lines...)
+		}
+	}
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+	return hunks
+}
+
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func sameTreeEntry(a, b map[string]object.TreeEntry, p string) bool {
+	ea, okA := a[p]
+	eb, okB := b[p]
+	if okA != okB {
+		return false
+	}
+	return !okA || ea.Hash == eb.Hash
+}
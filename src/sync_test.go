@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		local  string
+		remote string
+		want   string
+		ok     bool
+	}{
+		{
+			name:   "別々の行の編集",
+			base:   "a\nb\nc\nd\n",
+			local:  "A\nb\nc\nd\n",
+			remote: "a\nb\nc\nD\n",
+			want:   "A\nb\nc\nD\n",
+			ok:     true,
+		},
+		{
+			name:   "片方だけの編集",
+			base:   "a\nb\nc\n",
+			local:  "a\nb\nc\n",
+			remote: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+			ok:     true,
+		},
+		{
+			name:   "両方で同じ編集",
+			base:   "a\nb\nc\nd\n",
+			local:  "a\nB\nc\nD\n",
+			remote: "a\nB\nc\nd\n",
+			want:   "a\nB\nc\nD\n",
+			ok:     true,
+		},
+		{
+			name:   "同じ行の別々の編集",
+			base:   "a\nb\nc\n",
+			local:  "a\nB\nc\n",
+			remote: "a\nX\nc\n",
+			ok:     false,
+		},
+		{
+			name:   "重なる範囲の編集",
+			base:   "a\nb\nc\nd\n",
+			local:  "a\nB\nC\nd\n",
+			remote: "a\nb\nX\nY\n",
+			ok:     false,
+		},
+		{
+			name:   "片方が削除した行をもう片方が編集",
+			base:   "a\nb\nc\n",
+			local:  "a\nc\n",
+			remote: "a\nB\nc\n",
+			ok:     false,
+		},
+		{
+			name:   "末尾への追加と先頭の編集",
+			base:   "a\nb\n",
+			local:  "a\nb\nc\n",
+			remote: "A\nb\n",
+			want:   "A\nb\nc\n",
+			ok:     true,
+		},
+		{
+			name:   "両方で同じ内容を末尾に追加",
+			base:   "a\nb\n",
+			local:  "a\nb\nc\nd\n",
+			remote: "A\nb\nc\nd\n",
+			want:   "A\nb\nc\nd\n",
+			ok:     true,
+		},
+		{
+			name:   "両方で別の内容を末尾に追加",
+			base:   "a\nb\n",
+			local:  "a\nb\nc\n",
+			remote: "a\nb\nx\n",
+			ok:     false,
+		},
+		{
+			name:   "改行で終わらないファイルの別々の行の編集",
+			base:   "a\nb\nc",
+			local:  "A\nb\nc",
+			remote: "a\nb\nC",
+			want:   "A\nb\nC",
+			ok:     true,
+		},
+		{
+			name:   "改行で終わらないファイルへの追加",
+			base:   "a\nb\nc",
+			local:  "a\nb\nc\nd",
+			remote: "A\nb\nc",
+			want:   "A\nb\nc\nd",
+			ok:     true,
+		},
+		{
+			name:   "末尾の改行の有無だけが違う",
+			base:   "a\nb",
+			local:  "a\nb\n",
+			remote: "a\nB",
+			ok:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mergeLines([]byte(tt.base), []byte(tt.local), []byte(tt.remote))
+			if ok != tt.ok {
+				t.Fatalf("mergeLines ok = %v; want %v (結果 %q)", ok, tt.ok, got)
+			}
+			if ok && string(got) != tt.want {
+				t.Fatalf("mergeLines = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineHunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  string
+		other string
+		want  []lineHunk
+	}{
+		{
+			name:  "変更なし",
+			base:  "a\nb\n",
+			other: "a\nb\n",
+		},
+		{
+			name:  "行の置き換え",
+			base:  "a\nb\nc\n",
+			other: "a\nB\nc\n",
+			want:  []lineHunk{{start: 1, end: 2, lines: []string{"B\n"}}},
+		},
+		{
+			name:  "離れた2か所の編集",
+			base:  "a\nb\nc\nd\n",
+			other: "A\nb\nc\nD\n",
+			want: []lineHunk{
+				{start: 0, end: 1, lines: []string{"A\n"}},
+				{start: 3, end: 4, lines: []string{"D\n"}},
+			},
+		},
+		{
+			name:  "行の削除",
+			base:  "a\nb\nc\n",
+			other: "a\nc\n",
+			want:  []lineHunk{{start: 1, end: 2}},
+		},
+		{
+			name:  "末尾への追加",
+			base:  "a\nb\n",
+			other: "a\nb\nc\n",
+			want:  []lineHunk{{start: 2, end: 2, lines: []string{"c\n"}}},
+		},
+		{
+			name:  "改行で終わらない最後の行への追加",
+			base:  "a\nb",
+			other: "a\nb\nc",
+			want:  []lineHunk{{start: 1, end: 2, lines: []string{"b\n", "c"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lineHunks(tt.base, tt.other)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lineHunks = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validationIssue は保存前のチェックで見つかった問題。Severity が "error" なら保存しない。
+type validationIssue struct {
+	Validator string
+	Path      string
+	Line      int
+	Severity  string
+	Message   string
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+func (i validationIssue) String() string {
+	location := i.Path
+	if i.Line > 0 {
+		location = fmt.Sprintf("%s:%d", i.Path, i.Line)
+	}
+	return fmt.Sprintf("%s: %s", location, i.Message)
+}
+
+// validationError は保存を止めるエラーを含むチェック結果。
+type validationError struct {
+	Issues []validationIssue
+}
+
+func (e *validationError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+	return "validation failed: " + strings.Join(lines, "; ")
+}
+
+// validationFile はチェックするファイル。Path はリポジトリ相対パス。
+type validationFile struct {
+	Path    string
+	Content []byte
+}
+
+// validator は保存前のチェック。validators に追加すると、画面からの保存と
+// check コマンドの両方で使われる。
+type validator interface {
+	Name() string
+	Validate(a *app, file validationFile) []validationIssue
+}
+
+var validators = []validator{
+	headingValidator{},
+	markdownLintValidator{},
+	linkValidator{},
+	macroValidator{},
+	indexValidator{},
+	bannedWordsValidator{},
+}
+
+// validateFiles は files をすべての validator でチェックし、エラーと警告に分けて返す。
+func (a *app) validateFiles(files []validationFile) (errs, warnings []validationIssue) {
+	for _, file := range files {
+		for _, v := range validators {
+			for _, issue := range v.Validate(a, file) {
+				issue.Validator = v.Name()
+				if issue.Path == "" {
+					issue.Path = file.Path
+				}
+				if issue.Severity == severityError {
+					errs = append(errs, issue)
+				} else {
+					warnings = append(warnings, issue)
+				}
+			}
+		}
+	}
+	return errs, warnings
+}
+
+// validatePaths は作業コピー上の paths をチェックする。削除したファイルは対象外。
+// エラーがあれば *validationError を返す。
+func (a *app) validatePaths(paths []string) ([]validationIssue, error) {
+	var files []validationFile
+	for _, p := range paths {
+		data, err := os.ReadFile(a.repoAbsPath(p))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, validationFile{Path: p, Content: data})
+	}
+	errs, warnings := a.validateFiles(files)
+	if len(errs) > 0 {
+		return warnings, &validationError{Issues: errs}
+	}
+	return warnings, nil
+}
+
+// isManualPage はマニュアルのページ (manuals/ 以下の Markdown) かどうかを返す。
+func (a *app) isManualPage(p string) bool {
+	dir, err := computeGitPath(a.projectRoot, a.manualRoot, ".")
+	if err != nil {
+		return false
+	}
+	return inDir(p, dir) && strings.EqualFold(path.Ext(p), ".md")
+}
+
+// headingValidator はページに「# 見出し」があることを確かめる。目次やページの
+// タイトルは h1 から取るため、ないとエラーにする。コードの中の # の行は見出しとみなさない。
+type headingValidator struct{}
+
+func (headingValidator) Name() string { return "heading" }
+
+func (headingValidator) Validate(a *app, file validationFile) []validationIssue {
+	if !a.isManualPage(file.Path) {
+		return nil
+	}
+	doc, _ := parseMarkdown(string(file.Content))
+	for _, heading := range markdownHeadings(doc) {
+		if heading.level == 1 {
+			return nil
+		}
+	}
+	return []validationIssue{{Severity: severityError, Message: "ページの見出し (# タイトル) がありません"}}
+}
+
+// markdownHeadings は解析した Markdown の見出しを出てくる順に返す。
+func markdownHeadings(n *mdNode) []*mdNode {
+	if n.kind == mdHeading {
+		return []*mdNode{n}
+	}
+	var headings []*mdNode
+	for _, child := range n.children {
+		headings = append(headings, markdownHeadings(child)...)
+	}
+	return headings
+}
+
+// codeLines はコードブロックの行 (フェンスの行を含む) の行番号を返す。
+func codeLines(n *mdNode) map[int]bool {
+	lines := make(map[int]bool)
+	var walk func(n *mdNode)
+	walk = func(n *mdNode) {
+		if n.kind == mdCodeBlock {
+			last := n.startLine + len(n.lines) - 1
+			if n.fenced {
+				last++ // 閉じるフェンス
+			}
+			for line := n.startLine; line <= last; line++ {
+				lines[line] = true
+			}
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(n)
+	return lines
+}
+
+// markdownLintValidator は表示には影響しにくい書き方の揺れを警告する。
+type markdownLintValidator struct{}
+
+func (markdownLintValidator) Name() string { return "markdown" }
+
+var headingWithoutSpace = regexp.MustCompile(`^#{1,6}[^#\s]`)
+
+func (markdownLintValidator) Validate(a *app, file validationFile) []validationIssue {
+	if !a.isManualPage(file.Path) {
+		return nil
+	}
+	doc, _ := parseMarkdown(string(file.Content))
+	code := codeLines(doc)
+	var (
+		issues []validationIssue
+		blanks int
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(file.Content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		// コードは書いたとおりに表示するので確かめない
+		if code[n] {
+			blanks = 0
+			continue
+		}
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case hasStrayTrailingSpace(line):
+			issues = append(issues, validationIssue{Line: n, Severity: severityWarning, Message: "行末に余分な空白があります"})
+		case headingWithoutSpace.MatchString(trimmed):
+			issues = append(issues, validationIssue{Line: n, Severity: severityWarning, Message: "見出しの # の後に空白がありません"})
+		}
+
+		if trimmed == "" {
+			blanks++
+			if blanks == 3 {
+				issues = append(issues, validationIssue{Line: n, Severity: severityWarning, Message: "空行が3行以上続いています"})
+			}
+		} else {
+			blanks = 0
+		}
+	}
+
+	lastLevel := 0
+	for _, heading := range markdownHeadings(doc) {
+		if lastLevel > 0 && heading.level > lastLevel+1 {
+			issues = append(issues, validationIssue{Line: heading.startLine, Severity: severityWarning, Message: fmt.Sprintf("見出しのレベルが h%d から h%d に飛んでいます", lastLevel, heading.level)})
+		}
+		lastLevel = heading.level
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
+
+// hasStrayTrailingSpace は行末の空白が改行の指定 (半角空白2つ) 以外かどうかを返す。
+func hasStrayTrailingSpace(line string) bool {
+	trimmed := strings.TrimRight(line, " \t")
+	if trimmed == line || trimmed == "" {
+		return false
+	}
+	return line[len(trimmed):] != "  "
+}
+
+// linkValidator はページ内のリンクと画像のうち、サイト内を指すものの行き先があるかを確かめる。
+// Markdown として解析した結果を使うので、コードの中に書いた例は確かめない。
+type linkValidator struct{}
+
+func (linkValidator) Name() string { return "links" }
+
+func (linkValidator) Validate(a *app, file validationFile) []validationIssue {
+	if !a.isManualPage(file.Path) {
+		return nil
+	}
+	var issues []validationIssue
+	for _, ref := range markdownRefs(string(file.Content)) {
+		switch {
+		case ref.kind == inLink || ref.kind == inImage:
+			if msg := a.checkLinkTarget(file.Path, ref.dest); msg != "" {
+				issues = append(issues, validationIssue{Line: ref.line, Severity: severityError, Message: msg})
+			}
+		case ref.kind == inWikiLink:
+			// [[slug]] は目次になければ壊れたリンクとして表示されるだけなので、警告にとどめる
+			slug, _, _ := strings.Cut(strings.TrimSpace(ref.dest), "#")
+			if _, found := a.lookupPage(slug); slug != "" && !found {
+				issues = append(issues, validationIssue{Line: ref.line, Severity: severityWarning, Message: fmt.Sprintf("リンク先のページ %s が目次にありません", slug)})
+			}
+		case ref.kind == inMacro && ref.block && ref.dest == "include":
+			slug, _, _ := strings.Cut(strings.TrimSpace(ref.arg), "#")
+			if _, found := a.lookupPage(slug); !found {
+				issues = append(issues, validationIssue{Line: ref.line, Severity: severityWarning, Message: fmt.Sprintf("埋め込むページ %s が目次にありません", slug)})
+			}
+		}
+	}
+	return issues
+}
+
+// macroValidator は登録されていないマクロ ({{名前}}) を見つける。表示ではその場所に理由が出るだけなので警告にとどめる。
+type macroValidator struct{}
+
+func (macroValidator) Name() string { return "macros" }
+
+func (macroValidator) Validate(a *app, file validationFile) []validationIssue {
+	if !a.isManualPage(file.Path) {
+		return nil
+	}
+	var issues []validationIssue
+	for _, ref := range markdownRefs(string(file.Content)) {
+		if _, ok := macros[ref.dest]; ref.kind == inMacro && !ok {
+			issues = append(issues, validationIssue{Line: ref.line, Severity: severityWarning, Message: fmt.Sprintf("不明なマクロ {{%s}} があります", ref.dest)})
+		}
+	}
+	return issues
+}
+
+// checkLinkTarget はリンク先が見つからなければ理由を返す。外部のリンクは確かめない。
+func (a *app) checkLinkTarget(from, target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Sprintf("リンク %s を解釈できません", target)
+	}
+	if u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return ""
+	}
+
+	if strings.HasPrefix(u.Path, "/") {
+		if u.Path == "/" {
+			return ""
+		}
+		if slug, ok := strings.CutPrefix(u.Path, "/pages/"); ok {
+			if _, found := a.lookupPage(strings.TrimSuffix(slug, "/")); !found {
+				return fmt.Sprintf("リンク先のページ %s が目次にありません", slug)
+			}
+		}
+		return ""
+	}
+
+	// 相対パスはページのファイルからの位置で確かめる
+	abs := filepath.Join(filepath.Dir(a.repoAbsPath(from)), filepath.FromSlash(u.Path))
+	if !exists(abs) {
+		return fmt.Sprintf("リンク先のファイル %s がありません", u.Path)
+	}
+	return ""
+}
+
+// indexValidator は index.yaml を読み込めて、トップページがあることを確かめる。
+type indexValidator struct{}
+
+func (indexValidator) Name() string { return "index" }
+
+func (indexValidator) Validate(a *app, file validationFile) []validationIssue {
+	indexPath, err := computeGitPath(a.projectRoot, a.manualRoot, "index.yaml")
+	if err != nil || file.Path != indexPath {
+		return nil
+	}
+	pages, _, err := parseManualIndex(file.Content, a.projectRoot, a.manualRoot, true)
+	if err != nil {
+		return []validationIssue{{Severity: severityError, Message: "目次を読み込めません: " + err.Error()}}
+	}
+	if _, ok := pages["top"]; !ok {
+		return []validationIssue{{Severity: severityError, Message: "トップページ (slug: top) が定義されていません"}}
+	}
+	return nil
+}
+
+// bannedWordsValidator は manuals/banned-words.txt に挙げた語を含むページを保存させない。
+type bannedWordsValidator struct{}
+
+func (bannedWordsValidator) Name() string { return "banned-words" }
+
+func (bannedWordsValidator) Validate(a *app, file validationFile) []validationIssue {
+	if !a.isManualPage(file.Path) {
+		return nil
+	}
+	words, err := loadBannedWords(a.manualRoot)
+	if err != nil {
+		return []validationIssue{{Severity: severityWarning, Message: "禁止語の一覧を読み込めません: " + err.Error()}}
+	}
+	var issues []validationIssue
+	for n, line := range strings.Split(string(file.Content), "\n") {
+		for _, word := range words {
+			if strings.Contains(line, word) {
+				issues = append(issues, validationIssue{Line: n + 1, Severity: severityError, Message: fmt.Sprintf("使用しない語「%s」が含まれています", word)})
+			}
+		}
+	}
+	return issues
+}
+
+// loadBannedWords は1行1語の禁止語の一覧を読み込む。# で始まる行と空行は無視する。
+func loadBannedWords(manualRoot string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(manualRoot, "banned-words.txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	sort.Strings(words)
+	return words, nil
+}
+
+// runCheckCommand は check コマンド。画面から保存するときと同じチェックを作業コピーに対して行う。
+// ファイルを指定しなければ目次のすべてのページと index.yaml をチェックする。
+// エラーがあれば error を返す (Git の pre-commit フックからも使える)。
+func (a *app) runCheckCommand(args []string) error {
+	var paths []string
+	if len(args) == 0 {
+		indexPath, err := computeGitPath(a.projectRoot, a.manualRoot, "index.yaml")
+		if err != nil {
+			return err
+		}
+		paths = append(paths, indexPath)
+		pages, _ := a.manualIndex()
+		for _, meta := range pages {
+			paths = append(paths, meta.GitPath)
+		}
+		sort.Strings(paths[1:])
+	} else {
+		for _, arg := range args {
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(a.projectRoot, abs)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("%s はマニュアルのプロジェクトの外にあります", arg)
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+	}
+
+	warnings, err := a.validatePaths(paths)
+	for _, issue := range warnings {
+		fmt.Printf("警告\t%s\t(%s)\n", issue, issue.Validator)
+	}
+	var invalid *validationError
+	if errors.As(err, &invalid) {
+		for _, issue := range invalid.Issues {
+			fmt.Printf("エラー\t%s\t(%s)\n", issue, issue.Validator)
+		}
+		return fmt.Errorf("%d 件のエラーがあります", len(invalid.Issues))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d ファイルをチェックしました (警告 %d 件)\n", len(paths), len(warnings))
+	return nil
+}
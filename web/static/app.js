@@ -7,7 +7,125 @@ document.addEventListener("DOMContentLoaded", () => {
       const target = document.getElementById(id);
       if (target) {
         target.scrollIntoView({ behavior: "smooth", block: "start" });
+        // アドレスにも #見出し を反映して、そのまま共有できるようにする
+        history.replaceState(null, "", `#${encodeURIComponent(id)}`);
       }
     });
   });
+
+  // 更新履歴で、同じ編集者が続けて記録した版を1行にまとめる
+  const groupKey = "lfwiki-history-group";
+  const groupButtons = document.querySelectorAll("[data-history-group]");
+  const applyGrouping = (enabled) => {
+    document.querySelectorAll(".history__list[data-groupable]").forEach((list) => {
+      let head = null;
+      list.querySelectorAll(".history__item").forEach((item) => {
+        item.hidden = false;
+        item.querySelectorAll(".history__group-count").forEach((badge) => badge.remove());
+        const author = item.getAttribute("data-author");
+        if (!enabled || !author) {
+          head = null;
+          return;
+        }
+        if (head && head.author === author && !item.classList.contains("is-active")) {
+          item.hidden = true;
+          head.count += 1;
+          let badge = head.item.querySelector(".history__group-count");
+          if (!badge) {
+            badge = document.createElement("span");
+            badge.className = "history__group-count";
+            head.item.querySelector(".history__link").appendChild(badge);
+          }
+          badge.textContent = `ほか ${head.count} 件`;
+          return;
+        }
+        head = { item, author, count: 0 };
+      });
+    });
+    groupButtons.forEach((btn) => {
+      btn.setAttribute("aria-pressed", String(enabled));
+      btn.textContent = enabled ? "すべての版を表示" : "編集者ごとにまとめる";
+    });
+  };
+  if (groupButtons.length > 0) {
+    applyGrouping(localStorage.getItem(groupKey) === "1");
+    groupButtons.forEach((btn) => {
+      btn.addEventListener("click", () => {
+        const enabled = btn.getAttribute("aria-pressed") !== "true";
+        localStorage.setItem(groupKey, enabled ? "1" : "0");
+        applyGrouping(enabled);
+      });
+    });
+  }
+
+  const editorSelect = document.querySelector("[data-editor-select]");
+  const editorName = document.querySelector("[data-editor-name]");
+  if (editorSelect && editorName) {
+    editorSelect.addEventListener("change", () => {
+      editorName.hidden = editorSelect.value !== "";
+      if (!editorName.hidden) {
+        editorName.querySelector("input").focus();
+      }
+    });
+  }
+
+  document.querySelectorAll("form[data-confirm]").forEach((form) => {
+    form.addEventListener("submit", (event) => {
+      if (!window.confirm(form.getAttribute("data-confirm"))) {
+        event.preventDefault();
+      }
+    });
+  });
+
+  document.querySelectorAll("[data-auto-submit]").forEach((input) => {
+    input.addEventListener("change", () => input.form.submit());
+  });
+
+  const checklist = document.querySelector("[data-checklist]");
+  if (checklist) {
+    const stateEl = checklist.querySelector("[data-checklist-state]");
+    const states = JSON.parse(stateEl.textContent || "null") || {};
+    const boxes = document.querySelectorAll(".manual .task-list-item-checkbox[data-task]");
+    const doneEl = checklist.querySelector("[data-checklist-done]");
+    const describe = (box, state) => {
+      box.title = state && state.checked ? `${state.by} (${state.at})` : "";
+    };
+    const updateDone = () => {
+      doneEl.textContent = String(Array.from(boxes).filter((box) => box.checked).length);
+    };
+    boxes.forEach((box) => {
+      const task = box.getAttribute("data-task");
+      const state = states[task];
+      box.checked = Boolean(state && state.checked);
+      describe(box, state);
+      box.disabled = false;
+      box.addEventListener("change", async () => {
+        const body = new URLSearchParams({
+          action: "check",
+          page: checklist.getAttribute("data-page"),
+          shift: checklist.getAttribute("data-shift"),
+          task,
+          checked: box.checked ? "1" : "0",
+        });
+        checklist.querySelectorAll("[name=editor], [name=author]").forEach((field) => {
+          body.set(field.name, field.value);
+        });
+        box.disabled = true;
+        try {
+          const res = await fetch("/checklist", { method: "POST", body });
+          if (!res.ok) {
+            throw new Error(await res.text());
+          }
+          describe(box, await res.json());
+        } catch (err) {
+          box.checked = !box.checked;
+          window.alert(`チェックを記録できませんでした: ${err.message}`);
+        } finally {
+          box.disabled = false;
+          updateDone();
+        }
+      });
+    });
+    updateDone();
+  }
 });